@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// deletedFiles counts what deleteUserFiles removed from storage, as far as
+// the storage records know
+type deletedFiles struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// deleteUserFiles removes everything stored for a user's videos: the files of
+// every version, the thumbnails and whatever else was recorded against them.
+// It can be retried, so a failure part way leaves the account to try again.
+func (cfg *apiConfig) deleteUserFiles(ctx context.Context, userID uuid.UUID) (deletedFiles, error) {
+	objects, err := cfg.db.GetUserStoredObjectsContext(ctx, userID)
+	if err != nil {
+		return deletedFiles{}, err
+	}
+	deleted := deletedFiles{Files: len(objects)}
+	for _, object := range objects {
+		deleted.Bytes += object.Size
+	}
+
+	// Files stored before usage was recorded are only known from the videos
+	videos, err := cfg.db.GetVideosContext(ctx, userID)
+	if err != nil {
+		return deletedFiles{}, err
+	}
+	for _, video := range videos {
+		if err := cfg.deleteVideoFiles(ctx, video); err != nil {
+			return deletedFiles{}, err
+		}
+	}
+
+	// Whatever is still recorded wasn't reachable from the videos
+	objects, err = cfg.db.GetUserStoredObjectsContext(ctx, userID)
+	if err != nil {
+		return deletedFiles{}, err
+	}
+	for _, object := range objects {
+		if err := cfg.deleteStoredObject(ctx, object); err != nil {
+			return deletedFiles{}, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteVideoFiles removes everything stored for one video: the files of
+// every version, the thumbnails and whatever else was recorded against it
+func (cfg *apiConfig) deleteVideoFiles(ctx context.Context, video database.Video) error {
+	versions, err := cfg.db.GetVideoVersionsContext(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	if key, ok := cfg.keyFromCloudFrontURL(video.VideoURL); ok {
+		versions = append(versions, database.VideoVersion{
+			CreateVideoVersionParams: database.CreateVideoVersionParams{ObjectKey: key, Files: video.Files()},
+		})
+	}
+	for _, version := range versions {
+		// Everything of the video goes, so nothing is kept for another version
+		if err := cfg.deleteVersionFiles(ctx, version, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := cfg.deleteThumbnailFiles(ctx, video); err != nil {
+		return err
+	}
+
+	objects, err := cfg.db.GetVideoStoredObjectsContext(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := cfg.deleteStoredObject(ctx, object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteThumbnailFiles removes every variant of a video's thumbnail along
+// with their storage records
+func (cfg *apiConfig) deleteThumbnailFiles(ctx context.Context, video database.Video) error {
+	thumbnailURLs := []*string{video.ThumbnailURL}
+	for _, srcset := range video.ThumbnailSrcset {
+		for _, url := range srcsetURLs(srcset) {
+			thumbnailURLs = append(thumbnailURLs, &url)
+		}
+	}
+	for _, url := range thumbnailURLs {
+		if err := cfg.removeAssetByURL(ctx, url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteStoredObject removes a recorded object from wherever it's stored
+func (cfg *apiConfig) deleteStoredObject(ctx context.Context, object database.StoredObject) error {
+	if bucket, ok := strings.CutPrefix(object.Location, "s3://"); ok {
+		if err := cfg.deleteFromBucket(ctx, bucket, object.Key); err != nil {
+			return fmt.Errorf("couldn't delete %s: %w", object.Key, err)
+		}
+		return nil
+	}
+	if object.Location != assetsLocation {
+		return fmt.Errorf("unknown storage location %q", object.Location)
+	}
+	err := os.Remove(cfg.getAssetDiskPath(object.Key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return cfg.db.DeleteStoredObjectContext(ctx, object.Location, object.Key)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// authorizeAdmin checks that the request carries the admin API key and
+// responds with an error when it doesn't
+func (cfg *apiConfig) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.adminAPIKey == "" {
+		respondWithError(w, http.StatusForbidden, "Admin API is disabled", nil)
+		return false
+	}
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+		return false
+	}
+	return true
+}
@@ -186,9 +186,10 @@ async function uploadVideoFile(videoID) {
 
 const videoStateHandler = createVideoStateHandler();
 
-async function getVideos() {
+async function getVideos(cursor) {
   try {
-    const res = await fetch('/api/videos', {
+    const url = cursor ? `/api/videos?cursor=${encodeURIComponent(cursor)}` : '/api/videos';
+    const res = await fetch(url, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -199,15 +200,25 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const data = await res.json();
     const videoList = document.getElementById('video-list');
-    videoList.innerHTML = '';
-    for (const video of videos) {
+    if (!cursor) {
+      videoList.innerHTML = '';
+    }
+    videoList.querySelector('.load-more')?.remove();
+    for (const video of data.videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+    if (data.next_cursor) {
+      const loadMore = document.createElement('li');
+      loadMore.className = 'load-more';
+      loadMore.textContent = 'Load more...';
+      loadMore.onclick = () => getVideos(data.next_cursor);
+      videoList.appendChild(loadMore);
+    }
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
@@ -41,6 +42,31 @@ func (cfg apiConfig) getAssetURL(assetPath string) string {
 	// in main.go we have a file server that serves files from the /assets directory
 }
 
+// assetPathFromURL is the reverse of getAssetURL
+func (cfg apiConfig) assetPathFromURL(url *string) (string, bool) {
+	if url == nil {
+		return "", false
+	}
+	assetPath, ok := strings.CutPrefix(*url, cfg.getAssetURL(""))
+	if !ok || assetPath == "" || strings.Contains(assetPath, "/") {
+		return "", false
+	}
+	return assetPath, true
+}
+
+// removeAssetByURL deletes the file behind a URL made by getAssetURL
+func (cfg apiConfig) removeAssetByURL(ctx context.Context, url *string) error {
+	assetPath, ok := cfg.assetPathFromURL(url)
+	if !ok {
+		return nil
+	}
+	err := os.Remove(cfg.getAssetDiskPath(assetPath))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return cfg.db.DeleteStoredObjectContext(ctx, assetsLocation, assetPath)
+}
+
 func (cfg apiConfig) getObjectURL(key string) string {
 	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, key)
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// getAudioCodec returns the codec of the first audio stream, or an empty
+// string when the file has no audio
+func (cfg apiConfig) getAudioCodec(ctx context.Context, filePath string) (string, error) {
+	out, err := cfg.media.Run(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-select_streams", "a:0", "-show_entries", "stream=codec_name", filePath)
+	if err != nil {
+		return "", fmt.Errorf("ffprobe error: %w", err)
+	}
+	var output struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+		} `json:"streams"`
+	}
+	err = json.Unmarshal(out, &output)
+	if err != nil {
+		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	if len(output.Streams) == 0 {
+		return "", nil
+	}
+	return output.Streams[0].CodecName, nil
+}
+
+// processVideoForAudio extracts the audio track into an M4A file, copying the
+// stream as is when it's already AAC
+func (cfg apiConfig) processVideoForAudio(ctx context.Context, inputFilePath, sourceCodec string) (string, error) {
+	codecArgs := []string{"-c:a", "aac", "-b:a", "128k"}
+	if sourceCodec == "aac" {
+		codecArgs = []string{"-c:a", "copy"}
+	}
+	args := append([]string{"-i", inputFilePath, "-vn", "-map", "0:a:0"}, codecArgs...)
+	args = append(args, "-movflags", "faststart", "-f", "ipod")
+	return cfg.extractAudio(ctx, inputFilePath, "m4a", args)
+}
+
+func (cfg apiConfig) processVideoForMP3(ctx context.Context, inputFilePath string) (string, error) {
+	args := []string{"-i", inputFilePath, "-vn", "-map", "0:a:0", "-c:a", "libmp3lame", "-q:a", "4", "-f", "mp3"}
+	return cfg.extractAudio(ctx, inputFilePath, "mp3", args)
+}
+
+func (cfg apiConfig) extractAudio(ctx context.Context, inputFilePath, ext string, args []string) (string, error) {
+	outputFilePath := fmt.Sprintf("%s.%s", inputFilePath, ext)
+	if _, err := cfg.media.Run(ctx, "ffmpeg", append(args, outputFilePath)...); err != nil {
+		os.Remove(outputFilePath)
+		return "", fmt.Errorf("error extracting audio: %w", err)
+	}
+
+	fileInfo, err := os.Stat(outputFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat audio file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		os.Remove(outputFilePath)
+		return "", fmt.Errorf("audio file is empty")
+	}
+	return outputFilePath, nil
+}
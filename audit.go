@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log
+const (
+	auditUserCreate        = "user.create"
+	auditUserDelete        = "user.delete"
+	auditUserTierUpdate    = "user.tier_update"
+	auditLogin             = "auth.login"
+	auditLoginFailed       = "auth.login_failed"
+	auditTokenRefresh      = "auth.refresh"
+	auditTokenRevoke       = "auth.revoke"
+	auditVideoCreate       = "video.create"
+	auditVideoUpdate       = "video.update"
+	auditVideoDelete       = "video.delete"
+	auditVideoRestore      = "video.restore"
+	auditVideoPurge        = "video.purge"
+	auditVideoUpload       = "video.upload"
+	auditThumbnailUpload   = "video.thumbnail_upload"
+	auditVideoVersionPurge = "video.version_purge"
+	auditAdminReset        = "admin.reset"
+)
+
+// auditEntry is one event for the audit log. before and after are compared
+// field by field, by their JSON, into the stored diff; either can be nil for
+// things that were created or deleted.
+type auditEntry struct {
+	actorID    *uuid.UUID
+	action     string
+	targetType string
+	targetID   string
+	before     any
+	after      any
+}
+
+// videoAudit is an entry for a change to a video by the user making it
+func videoAudit(actorID uuid.UUID, action string, videoID uuid.UUID, before, after any) auditEntry {
+	return auditEntry{
+		actorID:    &actorID,
+		action:     action,
+		targetType: "video",
+		targetID:   videoID.String(),
+		before:     before,
+		after:      after,
+	}
+}
+
+// recordAudit appends an entry to the audit log along with where the request
+// came from; r is nil for events the server starts itself. A failure is
+// logged rather than failing the request.
+func (cfg *apiConfig) recordAudit(r *http.Request, entry auditEntry) {
+	diff, err := auditDiff(entry.before, entry.after)
+	if err != nil {
+		log.Printf("Couldn't diff audit event %s: %v", entry.action, err)
+	}
+	event := database.AuditEvent{
+		ActorID:    entry.actorID,
+		Action:     entry.action,
+		TargetType: entry.targetType,
+		TargetID:   entry.targetID,
+		Diff:       diff,
+	}
+	ctx := context.Background()
+	if r != nil {
+		// The action already happened, so the entry is kept even if the
+		// client goes away
+		ctx = context.WithoutCancel(r.Context())
+		event.IP = requestIP(r)
+		event.UserAgent = r.UserAgent()
+	}
+	err = cfg.db.RecordAuditEventContext(ctx, event)
+	if err != nil {
+		log.Printf("Couldn't record audit event %s: %v", entry.action, err)
+	}
+}
+
+// requestIP is the address the request came from. Forwarding headers are
+// ignored since any client can set them.
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type auditChange struct {
+	Old any `json:"old,omitempty"`
+	New any `json:"new,omitempty"`
+}
+
+// auditDiff returns the fields that differ between before and after, or nil
+// when nothing did. Passwords are never included, hashed or not.
+func auditDiff(before, after any) (json.RawMessage, error) {
+	oldFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]auditChange{}
+	for name, value := range oldFields {
+		if !reflect.DeepEqual(value, newFields[name]) {
+			changes[name] = auditChange{Old: value, New: newFields[name]}
+		}
+	}
+	for name, value := range newFields {
+		if _, ok := oldFields[name]; !ok && value != nil {
+			changes[name] = auditChange{New: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
+
+func auditFields(v any) (map[string]any, error) {
+	fields := map[string]any{}
+	if v == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "password")
+	return fields, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Matches description lines like "02:15 Setup" or "1:02:15 - Wrap up"
+var chapterLineRegexp = regexp.MustCompile(`^\s*(?:(\d{1,2}):)?(\d{1,2}):(\d{2})\s*(?:[-–|:]\s*)?(.+?)\s*$`)
+
+// parseChapters extracts a chapter list from a video description. Like other
+// platforms, a list only counts when it starts at 00:00, has at least two
+// entries and its timestamps are increasing.
+func parseChapters(description string) []database.Chapter {
+	chapters := []database.Chapter{}
+	for _, line := range strings.Split(description, "\n") {
+		matches := chapterLineRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		hours, _ := strconv.Atoi(matches[1])
+		minutes, _ := strconv.Atoi(matches[2])
+		seconds, _ := strconv.Atoi(matches[3])
+		if seconds > 59 || (matches[1] != "" && minutes > 59) {
+			continue
+		}
+		chapters = append(chapters, database.Chapter{
+			Start: float64(hours*3600 + minutes*60 + seconds),
+			Title: matches[4],
+		})
+	}
+
+	if err := validateChapters(chapters, nil); err != nil || len(chapters) < 2 || chapters[0].Start != 0 {
+		return []database.Chapter{}
+	}
+	return chapters
+}
+
+func validateChapters(chapters []database.Chapter, duration *float64) error {
+	for i, chapter := range chapters {
+		if strings.TrimSpace(chapter.Title) == "" {
+			return fmt.Errorf("chapter %d has no title", i+1)
+		}
+		// Either would end the cue early in the WebVTT track
+		if strings.ContainsAny(chapter.Title, "\r\n") || strings.Contains(chapter.Title, "-->") {
+			return fmt.Errorf("chapter %d's title can't contain line breaks or \"-->\"", i+1)
+		}
+		if chapter.Start < 0 {
+			return fmt.Errorf("chapter %d starts before the video", i+1)
+		}
+		if i > 0 && chapter.Start <= chapters[i-1].Start {
+			return fmt.Errorf("chapter %d doesn't start after the previous one", i+1)
+		}
+		if duration != nil && chapter.Start >= *duration {
+			return fmt.Errorf("chapter %d starts after the end of the video", i+1)
+		}
+	}
+	return nil
+}
+
+// chapterEnd returns when a chapter ends: at the start of the next chapter,
+// or at the end of the video for the last one
+func chapterEnd(chapters []database.Chapter, i int, duration float64) float64 {
+	if i+1 < len(chapters) {
+		return chapters[i+1].Start
+	}
+	return duration
+}
+
+func formatVTTTimestamp(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func chaptersToVTT(chapters []database.Chapter, duration float64) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i, chapter := range chapters {
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n%s\n",
+			i+1,
+			formatVTTTimestamp(chapter.Start),
+			formatVTTTimestamp(chapterEnd(chapters, i, duration)),
+			chapter.Title,
+		)
+	}
+	return b.String()
+}
+
+// writeChaptersMetadata writes the chapters in ffmpeg's FFMETADATA format so
+// they can be embedded into the MP4 and returns the file's path
+func writeChaptersMetadata(inputFilePath string, chapters []database.Chapter, duration float64) (string, error) {
+	escaper := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n")
+
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, chapter := range chapters {
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(chapter.Start*1000),
+			int64(chapterEnd(chapters, i, duration)*1000),
+			escaper.Replace(chapter.Title),
+		)
+	}
+
+	metadataFilePath := fmt.Sprintf("%s.chapters", inputFilePath)
+	if err := os.WriteFile(metadataFilePath, []byte(b.String()), 0600); err != nil {
+		return "", fmt.Errorf("could not write chapters metadata: %v", err)
+	}
+	return metadataFilePath, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestParseChapters(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        []database.Chapter
+	}{
+		{
+			name:        "minutes and seconds",
+			description: "My video\n\n00:00 Intro\n02:15 Setup\n10:03 Wrap up",
+			want: []database.Chapter{
+				{Start: 0, Title: "Intro"},
+				{Start: 135, Title: "Setup"},
+				{Start: 603, Title: "Wrap up"},
+			},
+		},
+		{
+			name:        "hours and separators",
+			description: "0:00 - Intro\n59:59 | Almost\n1:02:15 – The end",
+			want: []database.Chapter{
+				{Start: 0, Title: "Intro"},
+				{Start: 3599, Title: "Almost"},
+				{Start: 3735, Title: "The end"},
+			},
+		},
+		{
+			name:        "skips invalid timestamps",
+			description: "00:00 Intro\n01:75 Not a time\n1:60:00 Not either\n03:00 Outro",
+			want: []database.Chapter{
+				{Start: 0, Title: "Intro"},
+				{Start: 180, Title: "Outro"},
+			},
+		},
+		{
+			name:        "doesn't start at zero",
+			description: "00:05 Intro\n02:15 Setup",
+			want:        []database.Chapter{},
+		},
+		{
+			name:        "single chapter",
+			description: "00:00 Intro",
+			want:        []database.Chapter{},
+		},
+		{
+			name:        "timestamps out of order",
+			description: "00:00 Intro\n05:00 Middle\n03:00 Setup",
+			want:        []database.Chapter{},
+		},
+		{
+			name:        "no chapters",
+			description: "Just a description",
+			want:        []database.Chapter{},
+		},
+		{
+			name:        "empty description",
+			description: "",
+			want:        []database.Chapter{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseChapters(tt.description)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
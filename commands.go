@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// runCommand runs one of the admin subcommands instead of the server
+func runCommand(cfg *apiConfig, args []string) error {
+	switch args[0] {
+	case "reprocess":
+		return runReprocessCommand(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runReprocessCommand(cfg *apiConfig, args []string) error {
+	flags := flag.NewFlagSet("reprocess", flag.ContinueOnError)
+	videoID := flags.String("video", "", "reprocess a single video by ID")
+	userID := flags.String("user", "", "reprocess every video of a user by ID")
+	outdated := flags.Bool("outdated", false, "reprocess every video processed by an older pipeline")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	selection := reprocessSelection{Outdated: *outdated}
+	if *videoID != "" {
+		id, err := uuid.Parse(*videoID)
+		if err != nil {
+			return fmt.Errorf("invalid video ID: %w", err)
+		}
+		selection.VideoID = &id
+	}
+	if *userID != "" {
+		id, err := uuid.Parse(*userID)
+		if err != nil {
+			return fmt.Errorf("invalid user ID: %w", err)
+		}
+		selection.UserID = &id
+	}
+
+	ctx := context.Background()
+	videos, err := cfg.selectVideosForReprocessing(ctx, selection)
+	if err != nil {
+		return err
+	}
+	result := cfg.reprocessVideos(ctx, videos)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return err
+	}
+	if len(result.Failed) > 0 {
+		return errors.New("some videos couldn't be reprocessed")
+	}
+	return nil
+}
+
+// migrateOnStart brings the schema up to date before the server starts, or
+// only checks it is when automatic migrations are turned off
+func migrateOnStart(db database.Client, autoMigrate bool) error {
+	if !autoMigrate {
+		if err := db.CheckSchema(); err != nil {
+			return fmt.Errorf("%w, run \"tubely migrate\"", err)
+		}
+		return nil
+	}
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		log.Printf("Applied database migration %d (%s)", m.Version, m.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't migrate database: %w", err)
+	}
+	return nil
+}
+
+// runMigrateCommand handles "migrate status", "migrate up [-to N]" and
+// "migrate down [-to N]". Plain "migrate" applies every pending migration.
+func runMigrateCommand(db database.Client, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	if action == "status" {
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-30s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	}
+
+	flags := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	to := flags.Int("to", -1, "schema version to migrate to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	target, verb := *to, "Applied"
+	switch action {
+	case "up":
+		if target < 0 {
+			target = database.LatestMigration()
+		}
+		if target < current {
+			return fmt.Errorf("schema is already at version %d, use migrate down", current)
+		}
+	case "down":
+		verb = "Reverted"
+		if target < 0 {
+			// Without -to, revert just the newest migration
+			target = max(current-1, 0)
+		}
+		if target > current {
+			return fmt.Errorf("schema is only at version %d, use migrate up", current)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q, use status, up or down", action)
+	}
+
+	ran, err := db.MigrateTo(target)
+	for _, m := range ran {
+		fmt.Printf("%s migration %d (%s)\n", verb, m.Version, m.Name)
+	}
+	return err
+}
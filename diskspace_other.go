@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// freeDiskSpace can't tell the free space on this platform, so the check is
+// skipped
+func freeDiskSpace(path string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// freeDiskSpace returns the bytes available to unprivileged users on the
+// filesystem holding path
+func freeDiskSpace(path string) (uint64, bool, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, false, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true, nil
+}
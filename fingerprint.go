@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// Frames sampled across a video for its fingerprint
+	fingerprintFrames = 16
+	// Videos scoring at least this much are reported as likely duplicates
+	duplicateThreshold = 0.9
+)
+
+// computeFingerprint samples frames evenly across the video and takes the
+// dHash of each: the frame is shrunk to 9x8 grayscale pixels and every bit
+// records whether a pixel is brighter than its right neighbour
+func (cfg apiConfig) computeFingerprint(ctx context.Context, inputFilePath string, duration float64) (database.Fingerprint, error) {
+	filter := fmt.Sprintf("fps=%f,scale=9:8:flags=area,format=gray", fingerprintFrames/duration)
+	out, err := cfg.media.Run(ctx, "ffmpeg", "-v", "error", "-i", inputFilePath, "-an", "-vf", filter, "-f", "rawvideo", "-")
+	if err != nil {
+		return database.Fingerprint{}, fmt.Errorf("error sampling frames: %w", err)
+	}
+
+	const frameSize = 9 * 8
+	hashes := []uint64{}
+	for len(out) >= frameSize {
+		frame := out[:frameSize]
+		out = out[frameSize:]
+
+		var hash uint64
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				hash <<= 1
+				if frame[y*9+x] > frame[y*9+x+1] {
+					hash |= 1
+				}
+			}
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return database.Fingerprint{}, fmt.Errorf("no frames sampled")
+	}
+
+	return database.Fingerprint{Hashes: hashes, Duration: duration}, nil
+}
+
+// fingerprintSimilarity scores two fingerprints from 0 to 1, comparing frames
+// at the same relative position and weighting by how close the durations are
+func fingerprintSimilarity(a, b database.Fingerprint) float64 {
+	n := min(len(a.Hashes), len(b.Hashes))
+	if n == 0 || a.Duration <= 0 || b.Duration <= 0 {
+		return 0
+	}
+
+	matchingBits := 0
+	for i := 0; i < n; i++ {
+		hashA := a.Hashes[i*len(a.Hashes)/n]
+		hashB := b.Hashes[i*len(b.Hashes)/n]
+		matchingBits += 64 - bits.OnesCount64(hashA^hashB)
+	}
+	frameSimilarity := float64(matchingBits) / float64(n*64)
+	durationSimilarity := math.Min(a.Duration, b.Duration) / math.Max(a.Duration, b.Duration)
+	return frameSimilarity * durationSimilarity
+}
+
+type duplicateMatch struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Score   float64   `json:"score"`
+}
+
+// findDuplicates returns the candidates that likely duplicate the video, most
+// similar first
+func findDuplicates(videoID uuid.UUID, fingerprint database.Fingerprint, candidates []database.VideoFingerprint) []duplicateMatch {
+	matches := []duplicateMatch{}
+	for _, candidate := range candidates {
+		if candidate.VideoID == videoID {
+			continue
+		}
+		score := fingerprintSimilarity(fingerprint, candidate.Fingerprint)
+		if score >= duplicateThreshold {
+			matches = append(matches, duplicateMatch{VideoID: candidate.VideoID, Score: math.Round(score*1000) / 1000})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
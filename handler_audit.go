@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// parseAuditEventsParams reads the user, action and time range filters the
+// audit endpoints share
+func parseAuditEventsParams(query url.Values) (database.AuditEventsParams, error) {
+	params := database.AuditEventsParams{
+		Action: query.Get("action"),
+	}
+	if user := query.Get("user"); user != "" {
+		id, err := uuid.Parse(user)
+		if err != nil {
+			return params, errors.New("user must be a user ID")
+		}
+		params.ActorID = &id
+	}
+
+	var err error
+	if params.Since, err = parseOptionalTime(query, "since"); err != nil {
+		return params, err
+	}
+	if params.Until, err = parseOptionalTime(query, "until"); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// handlerAuditEvents returns one page of the audit log, newest first
+func (cfg *apiConfig) handlerAuditEvents(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Events     []database.AuditEvent `json:"events"`
+		NextCursor string                `json:"next_cursor,omitempty"`
+	}
+
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	params, err := parseAuditEventsParams(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.Limit = defaultAuditPageSize
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxAuditPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditPageSize), err)
+			return
+		}
+		params.Limit = n
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		id, err := decodeCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		params.After = &id
+	}
+
+	events, more, err := cfg.db.GetAuditEventsContext(r.Context(), params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audit events", err)
+		return
+	}
+
+	resp := response{Events: events}
+	if more {
+		resp.NextCursor = encodeCursor(events[len(events)-1].ID)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAuditExport streams every matching event, oldest first, as
+// newline-delimited JSON
+func (cfg *apiConfig) handlerAuditExport(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	params, err := parseAuditEventsParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.Ascending = true
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+
+	// The export streams as long as the client keeps reading
+	ctx := database.WithoutQueryTimeout(r.Context())
+	encoder := json.NewEncoder(w)
+	err = cfg.db.EachAuditEventContext(ctx, params, func(event database.AuditEvent) error {
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		// Too late for an error response, the client sees a truncated export
+		log.Printf("Couldn't export audit events: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerChaptersUpdate replaces a video's chapters. The stored rows and the
+// WebVTT track change right away; the chapters embedded in the MP4 follow once
+// the video has been reprocessed in the background. Like uploads, the request
+// waits for a processing slot and gets a 503 when the queue is full.
+func (cfg *apiConfig) handlerChaptersUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Chapters []database.Chapter `json:"chapters"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideoContext(r.Context(), videoID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Chapters == nil {
+		params.Chapters = []database.Chapter{}
+	}
+	if err := validateChapters(params.Chapters, video.Duration); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// Reprocessing starts from the stored original, so it needs one. The
+	// slot is taken before anything is saved so a full queue can be retried.
+	var release func()
+	if video.OriginalKey != nil {
+		release, err = cfg.processing.acquire(r.Context())
+		if err != nil {
+			if errors.Is(err, errProcessingQueueFull) {
+				w.Header().Set("Retry-After", strconv.Itoa(cfg.processingRetryAfter))
+				respondWithError(w, http.StatusServiceUnavailable, "Too many videos are being processed, try again later", err)
+				return
+			}
+			respondWithError(w, http.StatusServiceUnavailable, "Update cancelled while waiting to be processed", err)
+			return
+		}
+	}
+
+	err = cfg.db.SetChaptersContext(r.Context(), videoID, params.Chapters)
+	if err != nil {
+		if release != nil {
+			release()
+		}
+		respondWithDatabaseError(w, "Couldn't update chapters", err)
+		return
+	}
+
+	before := video
+	video.Chapters = params.Chapters
+	cfg.recordAudit(r, videoAudit(userID, auditVideoUpdate, video.ID, before, video))
+	if release != nil {
+		cfg.reprocessInBackground(video, release)
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerChaptersVTT(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideoContext(r.Context(), videoID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get video", err)
+		return
+	}
+	if video.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Video is in the trash", nil)
+		return
+	}
+	if video.Duration == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no uploaded file yet", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(chaptersToVTT(video.Chapters, *video.Duration)))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoDuplicates lists the caller's videos that likely duplicate one video
+func (cfg *apiConfig) handlerVideoDuplicates(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	fingerprint, err := cfg.db.GetFingerprintContext(r.Context(), video.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video hasn't been fingerprinted yet", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get fingerprint", err)
+		return
+	}
+
+	candidates, err := cfg.db.GetUserFingerprintsContext(r.Context(), video.UserID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get fingerprints", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, findDuplicates(video.ID, *fingerprint, candidates))
+}
+
+// handlerLibraryDuplicates lists every pair of likely duplicates in the
+// caller's library
+func (cfg *apiConfig) handlerLibraryDuplicates(w http.ResponseWriter, r *http.Request) {
+	type duplicatePair struct {
+		VideoID     uuid.UUID `json:"video_id"`
+		DuplicateID uuid.UUID `json:"duplicate_id"`
+		Score       float64   `json:"score"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	fingerprints, err := cfg.db.GetUserFingerprintsContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get fingerprints", err)
+		return
+	}
+
+	pairs := []duplicatePair{}
+	for i, fingerprint := range fingerprints {
+		// Only compare against later videos so each pair is listed once
+		for _, match := range findDuplicates(fingerprint.VideoID, fingerprint.Fingerprint, fingerprints[i+1:]) {
+			pairs = append(pairs, duplicatePair{
+				VideoID:     fingerprint.VideoID,
+				DuplicateID: match.VideoID,
+				Score:       match.Score,
+			})
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, pairs)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -28,21 +29,32 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
+	user, err := cfg.db.GetUserByEmailContext(r.Context(), params.Email)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
 	if err != nil {
+		cfg.recordAudit(r, auditEntry{
+			action:     auditLoginFailed,
+			targetType: "user",
+			after:      map[string]string{"email": params.Email},
+		})
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
 	match, err := auth.CheckPasswordHash(params.Password, user.Password)
-	if err != nil {
+	if err != nil || !match {
+		cfg.recordAudit(r, auditEntry{
+			action:     auditLoginFailed,
+			targetType: "user",
+			targetID:   user.ID.String(),
+			after:      map[string]string{"email": params.Email},
+		})
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
-	if !match {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
-		return
-	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
@@ -60,7 +72,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+	_, err = cfg.db.CreateRefreshTokenContext(r.Context(), database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
 		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
@@ -69,6 +81,12 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
 		return
 	}
+	cfg.recordAudit(r, auditEntry{
+		actorID:    &user.ID,
+		action:     auditLogin,
+		targetType: "user",
+		targetID:   user.ID.String(),
+	})
 
 	respondWithJSON(w, http.StatusOK, response{
 		User:         user,
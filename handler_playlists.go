@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// playlistDetail is a playlist with its videos resolved, in order
+type playlistDetail struct {
+	database.Playlist
+	Videos []database.Video `json:"videos"`
+}
+
+// getOwnedPlaylist loads the playlist named in the path, checking the caller
+// owns it. On failure the response has already been written.
+func (cfg *apiConfig) getOwnedPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return database.Playlist{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Playlist{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.GetPlaylistContext(r.Context(), playlistID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't access this playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+// respondWithPlaylist reloads a playlist after a change and sends it with
+// its videos
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, r *http.Request, status int, playlistID uuid.UUID) {
+	playlist, err := cfg.db.GetPlaylistContext(r.Context(), playlistID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get playlist", err)
+		return
+	}
+	videos, err := cfg.db.GetPlaylistVideosContext(r.Context(), playlistID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get playlist videos", err)
+		return
+	}
+	respondWithJSON(w, status, playlistDetail{Playlist: playlist, Videos: videos})
+}
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		database.CreatePlaylistParams
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.UserID = userID
+	if params.Visibility == "" {
+		params.Visibility = defaultPlaylistVisibility
+	}
+	if err := validatePlaylist(params.CreatePlaylistParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	playlist, err := cfg.db.CreatePlaylistContext(r.Context(), params.CreatePlaylistParams)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't create playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistsList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	playlists, err := cfg.db.GetPlaylistsContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get playlists", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+// handlerPlaylistGet returns a playlist with its videos. Private playlists
+// are only shown to their owner; anyone can open unlisted and public ones.
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+
+	playlist, err := cfg.db.GetPlaylistContext(r.Context(), playlistID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get playlist", err)
+		return
+	}
+
+	if playlist.Visibility == "private" {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+		if playlist.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You can't access this playlist", nil)
+			return
+		}
+	}
+
+	cfg.respondWithPlaylist(w, r, http.StatusOK, playlist.ID)
+}
+
+// handlerPlaylistUpdate renames a playlist or changes its description or
+// visibility. Fields left out of the request keep their values.
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil {
+		playlist.Title = *params.Title
+	}
+	if params.Description != nil {
+		playlist.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		playlist.Visibility = *params.Visibility
+	}
+	if err := validatePlaylist(playlist.CreatePlaylistParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.UpdatePlaylistContext(r.Context(), playlist)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't update playlist", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, r, http.StatusOK, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeletePlaylistContext(r.Context(), playlist.ID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't delete playlist", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistVideoAdd appends one of the caller's videos to a playlist
+func (cfg *apiConfig) handlerPlaylistVideoAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID uuid.UUID `json:"video_id"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideoContext(r.Context(), params.VideoID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get video", err)
+		return
+	}
+	if video.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != playlist.UserID {
+		respondWithError(w, http.StatusForbidden, "You can only add your own videos", nil)
+		return
+	}
+
+	err = cfg.db.AddPlaylistVideoContext(r.Context(), playlist.ID, video.ID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't add video", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, r, http.StatusOK, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoRemove(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	err = cfg.db.RemovePlaylistVideoContext(r.Context(), playlist.ID, videoID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't remove video", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, r, http.StatusOK, playlist.ID)
+}
+
+// handlerPlaylistReorder puts a playlist's videos in a new order in one go.
+// The request must list every video in the playlist exactly once.
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err = cfg.db.ReorderPlaylistContext(r.Context(), playlist.ID, params.VideoIDs)
+	if errors.Is(err, database.ErrPlaylistMismatch) {
+		respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist exactly once", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, r, http.StatusOK, playlist.ID)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
@@ -18,9 +20,13 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	user, err := cfg.db.GetUserByRefreshTokenContext(r.Context(), refreshToken)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
 		return
 	}
 
@@ -33,6 +39,12 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
 		return
 	}
+	cfg.recordAudit(r, auditEntry{
+		actorID:    &user.ID,
+		action:     auditTokenRefresh,
+		targetType: "user",
+		targetID:   user.ID.String(),
+	})
 
 	respondWithJSON(w, http.StatusOK, response{
 		Token: accessToken,
@@ -46,11 +58,19 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	// Only for the audit log, so a token that's already expired is no error
+	entry := auditEntry{action: auditTokenRevoke, targetType: "user"}
+	if user, err := cfg.db.GetUserByRefreshTokenContext(r.Context(), refreshToken); err == nil {
+		entry.actorID = &user.ID
+		entry.targetID = user.ID.String()
+	}
+
+	err = cfg.db.RevokeRefreshTokenContext(r.Context(), refreshToken)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		respondWithDatabaseError(w, "Couldn't revoke session", err)
 		return
 	}
+	cfg.recordAudit(r, entry)
 
 	w.WriteHeader(http.StatusNoContent)
 }
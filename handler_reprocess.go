@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerReprocess starts reprocessing the selected videos in the background
+// and answers with the job, which handlerReprocessJob reports on
+func (cfg *apiConfig) handlerReprocess(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := reprocessSelection{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	videos, err := cfg.selectVideosForReprocessing(r.Context(), params)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	job := cfg.reprocessJobs.start(cfg, videos)
+	w.Header().Set("Location", "/admin/reprocess/"+job.ID.String())
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+func (cfg *apiConfig) handlerReprocessJob(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	job, ok := cfg.reprocessJobs.get(jobID)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Reprocess job not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, job)
+}
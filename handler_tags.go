@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoTagsGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Tags []string `json:"tags"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideoContext(r.Context(), videoID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Tags: video.Tags})
+}
+
+// handlerVideoTagsUpdate replaces a video's tags. Tags are case-insensitive
+// and stored lowercased.
+func (cfg *apiConfig) handlerVideoTagsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateTags(params.Tags); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.SetVideoTagsContext(r.Context(), video.ID, params.Tags)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't update tags", err)
+		return
+	}
+
+	before := video
+	video.Tags, err = cfg.db.GetVideoTagsContext(r.Context(), video.ID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get tags", err)
+		return
+	}
+	cfg.recordAudit(r, videoAudit(video.UserID, auditVideoUpdate, video.ID, before, video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoCategoryUpdate sets a video's category, or clears it when the
+// category is null or empty
+func (cfg *apiConfig) handlerVideoCategoryUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Category *string `json:"category"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateCategory(params.Category); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	before := video
+	video.Category = params.Category
+	err = cfg.db.UpdateVideoContext(r.Context(), video)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't update category", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideoContext(r.Context(), video.ID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get video", err)
+		return
+	}
+	cfg.recordAudit(r, videoAudit(video.UserID, auditVideoUpdate, video.ID, before, video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerTagsList lists the tags on the user's videos with how many videos
+// carry each
+func (cfg *apiConfig) handlerTagsList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	tags, err := cfg.db.GetUserTagsContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func (cfg *apiConfig) handlerTrashList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.GetTrashedVideosContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get trash", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// getTrashedVideo loads the caller's video named in the path, checking it's
+// in the trash. On failure the response has already been written.
+func (cfg *apiConfig) getTrashedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return database.Video{}, false
+	}
+	if video.DeletedAt == nil {
+		respondWithError(w, http.StatusNotFound, "Video isn't in the trash", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) handlerTrashRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getTrashedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.RestoreVideoContext(r.Context(), video.ID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't restore video", err)
+		return
+	}
+
+	before := video
+	video.DeletedAt = nil
+	cfg.recordAudit(r, videoAudit(video.UserID, auditVideoRestore, video.ID, before, video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerTrashPurge permanently deletes a video in the trash without waiting
+// for the retention period
+func (cfg *apiConfig) handlerTrashPurge(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getTrashedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.purgeVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't purge video", err)
+		return
+	}
+	cfg.recordAudit(r, videoAudit(video.UserID, auditVideoPurge, video.ID, video, nil))
+
+	w.WriteHeader(http.StatusNoContent)
+}
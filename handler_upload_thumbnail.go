@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"image"
 	"io"
+	"log"
 	"mime"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
@@ -31,20 +36,53 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Get the video's metadata
-	dbVideo, err := cfg.db.GetVideo(videoID)
+	dbVideo, err := cfg.db.GetVideoContext(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		respondWithDatabaseError(w, "Couldn't find video", err)
 		return
 	}
 	// Authorize user as video owner
 	if dbVideo.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", err)
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	// The quota checks go by the declared size, which chunked uploads don't have
+	if r.ContentLength < 0 {
+		respondWithError(w, http.StatusLengthRequired, "Content-Length is required", nil)
+		return
+	}
+
+	tier, policy, err := cfg.userUploadPolicy(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get upload policy", err)
+		return
+	}
+	used, err := cfg.db.GetUserStorageTotalContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get storage usage", err)
+		return
+	}
+	if err := policy.checkStorageQuota(tier, used, r.ContentLength); err != nil {
+		respondWithPolicyViolation(w, err)
 		return
 	}
 
 	// Upload
 	const maxMemory = 10 << 20 // Set to 10MB
-	r.ParseMultipartForm(maxMemory)
+	if policy.MaxThumbnailSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, policy.MaxThumbnailSize)
+	}
+	err = r.ParseMultipartForm(maxMemory)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("The %s tier is limited to thumbnails of %s", tier, formatFileSize(policy.MaxThumbnailSize)), err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return
+	}
 
 	// "thumbnail" should match the HTML form input name
 	file, header, err := r.FormFile("thumbnail")
@@ -65,30 +103,71 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	assetPath := getAssetPath(mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	// Check the dimensions before decoding the whole image
+	imgConfig, _, err := image.DecodeConfig(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Thumbnail is not a valid JPEG or PNG image", err)
+		return
+	}
+	if imgConfig.Width > maxThumbnailWidth || imgConfig.Height > maxThumbnailHeight {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Thumbnail dimensions can't exceed %dx%d", maxThumbnailWidth, maxThumbnailHeight), nil)
+		return
+	}
+
+	// A full-size image takes up to 256MB once decoded, so only a few are
+	// decoded and resized at once
+	release, err := cfg.thumbnailProcessing.acquire(r.Context())
+	if err != nil {
+		if errors.Is(err, errProcessingQueueFull) {
+			w.Header().Set("Retry-After", strconv.Itoa(cfg.processingRetryAfter))
+			respondWithError(w, http.StatusServiceUnavailable, "Too many thumbnails are being processed, try again later", err)
+			return
+		}
+		respondWithError(w, http.StatusServiceUnavailable, "Upload cancelled while waiting to be processed", err)
+		return
+	}
+	defer release()
 
-	// Create the new file in the system
-	dst, err := os.Create(assetDiskPath)
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
+		return
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Thumbnail image is corrupt", err)
+		return
+	}
+
+	// Re-encode without metadata, then resize into the responsive variants
+	cleanFilePath, err := writeCleanImage(img, cfg.assetsRoot)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
+		respondWithError(w, http.StatusInternalServerError, "Error processing thumbnail", err)
 		return
 	}
-	defer dst.Close()
-	// Copy contents from multipart file to system file
-	if _, err = io.Copy(dst, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+	defer os.Remove(cleanFilePath)
+
+	srcset, err := cfg.generateThumbnailVariants(r.Context(), cleanFilePath, img.Bounds().Dx(), videoOwner(dbVideo, storageThumbnails))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resizing thumbnail", err)
 		return
 	}
 
-	url := cfg.getAssetURL(assetPath)
+	// The largest JPEG stays the default thumbnail for clients that ignore the srcset
+	before := dbVideo
+	url := largestSrcsetURL(srcset["image/jpeg"])
 	dbVideo.ThumbnailURL = &url
+	dbVideo.ThumbnailSrcset = srcset
 
-	err = cfg.db.UpdateVideo(dbVideo)
+	err = cfg.db.UpdateVideoContext(r.Context(), dbVideo)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		respondWithDatabaseError(w, "Couldn't update video", err)
 		return
 	}
+	// The new thumbnail is already in place, so leftovers are only logged
+	if err := cfg.deleteThumbnailFiles(r.Context(), before); err != nil {
+		log.Printf("Couldn't delete the previous thumbnail of video %s: %v", dbVideo.ID, err)
+	}
+	cfg.recordAudit(r, videoAudit(userID, auditThumbnailUpload, dbVideo.ID, before, dbVideo))
 
 	respondWithJSON(w, http.StatusOK, dbVideo)
 }
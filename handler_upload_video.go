@@ -1,7 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,16 +12,19 @@ import (
 	"mime"
 	"net/http"
 	"os"
-	"os/exec"
-	"path"
+	"strconv"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Video
+		PossibleDuplicates []duplicateMatch `json:"possible_duplicates,omitempty"`
+	}
+
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
@@ -40,24 +45,78 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Get the video's metadata
-	dbVideo, err := cfg.db.GetVideo(videoID)
+	dbVideo, err := cfg.db.GetVideoContext(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		respondWithDatabaseError(w, "Couldn't find video", err)
 		return
 	}
 	// Authorize user as video owner
 	if dbVideo.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", err)
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	// The quota checks go by the declared size, which chunked uploads don't have
+	if r.ContentLength < 0 {
+		respondWithError(w, http.StatusLengthRequired, "Content-Length is required", nil)
+		return
+	}
+
+	// Check the limits of the user's tier that don't need the file
+	tier, policy, err := cfg.userUploadPolicy(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get upload policy", err)
+		return
+	}
+	if dbVideo.VideoURL == nil {
+		count, err := cfg.db.CountUploadedVideosContext(r.Context(), userID)
+		if err != nil {
+			respondWithDatabaseError(w, "Couldn't count videos", err)
+			return
+		}
+		if err := policy.checkVideoCount(tier, count); err != nil {
+			respondWithPolicyViolation(w, err)
+			return
+		}
+	}
+	if r.ContentLength > policy.MaxFileSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fileSizeLimitMessage(tier, policy.MaxFileSize), nil)
+		return
+	}
+	used, err := cfg.db.GetUserStorageTotalContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get storage usage", err)
+		return
+	}
+	if err := policy.checkStorageQuota(tier, used, r.ContentLength); err != nil {
+		respondWithPolicyViolation(w, err)
+		return
+	}
+
+	// The upload, its processed copy and the renditions all land on disk
+	// before they are stored elsewhere
+	free, ok, err := freeDiskSpace(cfg.assetsRoot)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check free disk space", err)
+		return
+	}
+	if ok && int64(free) < 2*r.ContentLength+cfg.minFreeDisk {
+		w.Header().Set("Retry-After", strconv.Itoa(cfg.processingRetryAfter))
+		respondWithError(w, http.StatusServiceUnavailable, "Not enough disk space to accept the upload, try again later", nil)
 		return
 	}
 
 	// Upload
-	const uploadLimit = 1 << 30 // Set to 1GB
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+	r.Body = http.MaxBytesReader(w, r.Body, policy.MaxFileSize)
 
 	// "video" should match the HTML form input name
 	file, header, err := r.FormFile("video")
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fileSizeLimitMessage(tier, policy.MaxFileSize), err)
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
@@ -74,133 +133,225 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Invalid file type, only MP4 is allowed", nil)
 		return
 	}
-
-	// Create temp empty system file on which to write the unprocessed video
-	tempFile, err := os.CreateTemp(cfg.assetsRoot, "tubely-upload-*.mp4")
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
-		return
-	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
-
-	// Copy contents from multipart file to temp empty system file
-	if _, err = io.Copy(tempFile, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
+	if err := policy.checkContainer(tier, mediaType); err != nil {
+		respondWithPolicyViolation(w, err)
 		return
 	}
 
-	// Reset the tempFile's file pointer to the beginning to allow us to read the file again from the beginning
-	_, err = tempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
+	// Optional 9:16 rendition for landscape uploads ("crop" or "blur")
+	verticalMode := r.FormValue("vertical_mode")
+	if verticalMode != "" && verticalMode != verticalModeCrop && verticalMode != verticalModeBlur {
+		respondWithError(w, http.StatusBadRequest, "Invalid vertical_mode, must be crop or blur", nil)
 		return
 	}
 
-	// Get the aspect ratio of the video file
-	directory := ""
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	// Create temp empty system file on which to write the unprocessed video
+	tempFile, err := os.CreateTemp(cfg.assetsRoot, "tubely-upload-*.mp4")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
 		return
 	}
-	switch aspectRatio {
-	case "16:9":
-		directory = "landscape"
-	case "9:16":
-		directory = "portrait"
-	default:
-		directory = "other"
-	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
 
-	// Create a processed version of the video
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	// Copy contents from multipart file to temp empty system file, hashing it
+	// on the way for the version history
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
 		return
 	}
-	defer os.Remove(processedFilePath)
 
-	processedFile, err := os.Open(processedFilePath)
+	// Wait for a processing slot so a burst of uploads can't overload the server.
+	// Only taken once the upload is on disk, so slow clients don't hold one.
+	release, err := cfg.processing.acquire(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not open processed file", err)
+		if errors.Is(err, errProcessingQueueFull) {
+			w.Header().Set("Retry-After", strconv.Itoa(cfg.processingRetryAfter))
+			respondWithError(w, http.StatusServiceUnavailable, "Too many videos are being processed, try again later", err)
+			return
+		}
+		respondWithError(w, http.StatusServiceUnavailable, "Upload cancelled while waiting to be processed", err)
 		return
 	}
-	defer processedFile.Close()
+	defer release()
 
-	// Put the object into S3
-	key := getAssetPath(mediaType)
-	key = path.Join(directory, key)
-	putObjectInput := s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key), // The file name using <random-32-byte-hex>.ext format
-		Body:        processedFile,
-		ContentType: aws.String(mediaType),
-	}
-	_, err = cfg.s3Client.PutObject(r.Context(), &putObjectInput)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
-		return
+	job := &videoJob{
+		video:        dbVideo,
+		inputPath:    tempFile.Name(),
+		mediaType:    mediaType,
+		verticalMode: verticalMode,
+		uploaderID:   userID,
+		size:         size,
+		checksum:     hex.EncodeToString(hash.Sum(nil)),
+		tier:         tier,
+		policy:       policy,
 	}
+	// The new file starts without any of the files of the one it replaces,
+	// so steps that don't apply to it leave them off the new version
+	job.video.SetFiles(database.VideoFiles{})
+	defer job.removeTempFiles()
 
-	// Update the VideoURL of the video record in the database with the cloudfront URL
-	videoURL := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
-	dbVideo.VideoURL = &videoURL
-	err = cfg.db.UpdateVideo(dbVideo)
+	_, err = cfg.pipeline.run(r.Context(), cfg, job)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		var violation *policyViolation
+		if errors.As(err, &violation) {
+			respondWithPolicyViolation(w, violation)
+			return
+		}
+		// The video may have been deleted while it was processing
+		respondWithDatabaseError(w, "Error processing video", err)
 		return
 	}
+	cfg.recordAudit(r, videoAudit(userID, auditVideoUpload, dbVideo.ID, dbVideo, job.video))
 
-	respondWithJSON(w, http.StatusOK, dbVideo)
+	// Warn about near-duplicates without refusing the upload
+	respondWithJSON(w, http.StatusOK, response{
+		Video:              job.video,
+		PossibleDuplicates: job.possibleDuplicates,
+	})
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
+// getVideoDimensions reads the display width and height of the first video
+// stream with ffprobe
+func (cfg apiConfig) getVideoDimensions(ctx context.Context, filePath string) (int, int, error) {
 	// Get "streams" video info
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	out, err := cmd.Output()
+	out, err := cfg.media.Run(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
 	if err != nil {
-		return "", fmt.Errorf("ffprobe error: %v", err)
+		return 0, 0, fmt.Errorf("ffprobe error: %w", err)
 	}
+	return parseVideoDimensions(out)
+}
+
+// parseVideoDimensions picks the first video stream out of ffprobe's JSON.
+// Width and height are swapped for streams rotated by 90 or 270 degrees, the
+// way the MP4 parser does it.
+func parseVideoDimensions(out []byte) (int, int, error) {
 	// Unmarshal the stdout of the command into a JSON struct
 	var output struct {
 		Streams []struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
+			CodecType    string `json:"codec_type"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			SideDataList []struct {
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+			Tags struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
 		} `json:"streams"`
 	}
-	err = json.Unmarshal(out, &output)
+	err := json.Unmarshal(out, &output)
 	if err != nil {
-		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
+		return 0, 0, fmt.Errorf("could not parse ffprobe output: %v", err)
 	}
-	// The ffprobe can return an empty array of streams
-	if len(output.Streams) == 0 {
-		return "", errors.New("no video streams found")
+
+	for _, stream := range output.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		// Newer ffprobe reports the display matrix as side data, older
+		// versions as a rotate tag
+		rotation := 0
+		for _, sideData := range stream.SideDataList {
+			if sideData.Rotation != 0 {
+				rotation = int(math.Round(sideData.Rotation))
+			}
+		}
+		if rotation == 0 && stream.Tags.Rotate != "" {
+			rotation, _ = strconv.Atoi(stream.Tags.Rotate)
+		}
+		rotation = (rotation%360 + 360) % 360
+		if rotation == 90 || rotation == 270 {
+			return stream.Height, stream.Width, nil
+		}
+		return stream.Width, stream.Height, nil
 	}
+	// The ffprobe can return an empty array of streams
+	return 0, 0, errors.New("no video streams found")
+}
 
+func aspectRatioFromDimensions(w, h int) string {
 	// Determine video's aspect ratio
-	width := float64(output.Streams[0].Width)
-	height := float64(output.Streams[0].Height)
+	width := float64(w)
+	height := float64(h)
 	// 9 / 16 = 0.562962963
 	// 16 / 9 = 1.7777777778
 	ratio := math.Floor((width/height)*100) / 100
 	if ratio > 0.54 && ratio < 0.58 {
-		return "9:16", nil
+		return "9:16"
 	} else if ratio > 1.74 && ratio < 1.78 {
-		return "16:9", nil
+		return "16:9"
 	}
-	return "other", nil
+	return "other"
 }
 
-func processVideoForFastStart(inputFilePath string) (string, error) {
+const (
+	verticalModeCrop = "crop"
+	verticalModeBlur = "blur"
+)
+
+// processVideoForVertical renders a 9:16 version of a landscape video, either
+// by center-cropping it or by fitting it over a blurred copy of itself
+func (cfg apiConfig) processVideoForVertical(ctx context.Context, inputFilePath, mode string) (string, error) {
+	processedFilePath := fmt.Sprintf("%s.vertical", inputFilePath)
+
+	var filterArgs []string
+	switch mode {
+	case verticalModeCrop:
+		filterArgs = []string{"-vf", "crop=trunc(ih*9/16/2)*2:ih,setsar=1"}
+	case verticalModeBlur:
+		filter := "[0:v]split=2[bg][fg];" +
+			"[bg]scale=-2:trunc(ih*16/9/2)*2,crop=trunc(ih*9/16/2)*2:ih,boxblur=20:5[bgblur];" +
+			"[fg]scale=trunc(ih/2)*2:-2[fgscaled];" +
+			"[bgblur][fgscaled]overlay=(W-w)/2:(H-h)/2,setsar=1[v]"
+		filterArgs = []string{"-filter_complex", filter, "-map", "[v]", "-map", "0:a?"}
+	default:
+		return "", fmt.Errorf("unknown vertical mode: %s", mode)
+	}
+
+	args := []string{"-i", inputFilePath}
+	args = append(args, filterArgs...)
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+		"-c:a", "copy",
+		"-movflags", "faststart", "-f", "mp4", processedFilePath,
+	)
+	if _, err := cfg.media.Run(ctx, "ffmpeg", args...); err != nil {
+		os.Remove(processedFilePath)
+		return "", fmt.Errorf("error processing vertical video: %w", err)
+	}
+
+	fileInfo, err := os.Stat(processedFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat vertical file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		return "", fmt.Errorf("vertical file is empty")
+	}
+
+	return processedFilePath, nil
+}
+
+func (cfg apiConfig) processVideoForFastStart(ctx context.Context, inputFilePath string, chapters []database.Chapter, duration float64) (string, error) {
 	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
-	// Process filePath video
-	cmd := exec.Command("ffmpeg", "-i", inputFilePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", processedFilePath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
+	args := []string{"-i", inputFilePath}
+	// Embed the chapters as MP4 chapter metadata
+	if len(chapters) > 0 {
+		metadataFilePath, err := writeChaptersMetadata(inputFilePath, chapters, duration)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(metadataFilePath)
+		args = append(args, "-i", metadataFilePath, "-map_chapters", "1")
+	}
+	args = append(args, "-c", "copy", "-movflags", "faststart", "-f", "mp4", processedFilePath)
+
+	if _, err := cfg.media.Run(ctx, "ffmpeg", args...); err != nil {
+		os.Remove(processedFilePath)
+		return "", fmt.Errorf("error processing video: %w", err)
 	}
 
 	// Checks file integrity (if file can be described and has data)
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestParseVideoDimensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		out        string
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{
+			name:       "landscape",
+			out:        `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080}]}`,
+			wantWidth:  1920,
+			wantHeight: 1080,
+		},
+		{
+			name:       "audio stream first",
+			out:        `{"streams": [{"codec_type": "audio"}, {"codec_type": "video", "width": 1280, "height": 720}]}`,
+			wantWidth:  1280,
+			wantHeight: 720,
+		},
+		{
+			name:       "rotated 90 degrees in side data",
+			out:        `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080, "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]}]}`,
+			wantWidth:  1080,
+			wantHeight: 1920,
+		},
+		{
+			name:       "rotated 270 degrees in side data",
+			out:        `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080, "side_data_list": [{"side_data_type": "Display Matrix", "rotation": 90}]}]}`,
+			wantWidth:  1080,
+			wantHeight: 1920,
+		},
+		{
+			name:       "rotated 180 degrees in side data",
+			out:        `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080, "side_data_list": [{"side_data_type": "Display Matrix", "rotation": 180}]}]}`,
+			wantWidth:  1920,
+			wantHeight: 1080,
+		},
+		{
+			name:       "rotated 90 degrees in tags",
+			out:        `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080, "tags": {"rotate": "90"}}]}`,
+			wantWidth:  1080,
+			wantHeight: 1920,
+		},
+		{
+			name:    "no video stream",
+			out:     `{"streams": [{"codec_type": "audio"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			out:     `{"streams": [`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, err := parseVideoDimensions([]byte(tt.out))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %dx%d, want an error", width, height)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseVideoDimensions: %v", err)
+			}
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("got %dx%d, want %dx%d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
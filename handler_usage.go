@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerUsageGet reports how much storage the caller is using per category
+func (cfg *apiConfig) handlerUsageGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Tier       string                   `json:"tier"`
+		TotalBytes int64                    `json:"total_bytes"`
+		QuotaBytes int64                    `json:"quota_bytes,omitempty"`
+		Categories []database.CategoryUsage `json:"categories"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	tier, policy, err := cfg.userUploadPolicy(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get upload policy", err)
+		return
+	}
+	usage, err := cfg.db.GetUserUsageContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get storage usage", err)
+		return
+	}
+
+	total := int64(0)
+	for _, category := range usage {
+		total += category.Bytes
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Tier:       tier,
+		TotalBytes: total,
+		QuotaBytes: policy.StorageQuota,
+		Categories: usage,
+	})
+}
+
+// handlerStorageReport lists the users storing the most bytes
+func (cfg *apiConfig) handlerStorageReport(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		limit = parsed
+	}
+
+	consumers, err := cfg.db.GetTopStorageConsumersContext(r.Context(), limit)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get storage report", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, consumers)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// handlerUserTierUpdate moves a user onto another upload policy tier
+func (cfg *apiConfig) handlerUserTierUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tier string `json:"tier"`
+	}
+	type response struct {
+		ID    uuid.UUID `json:"id"`
+		Email string    `json:"email"`
+		Tier  string    `json:"tier"`
+	}
+
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if _, ok := cfg.uploadPolicies.Tiers[params.Tier]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown tier", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get user", err)
+		return
+	}
+
+	err = cfg.db.SetUserTierContext(r.Context(), userID, params.Tier)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't update tier", err)
+		return
+	}
+	// Admin requests authenticate with the API key, so there's no actor
+	cfg.recordAudit(r, auditEntry{
+		action:     auditUserTierUpdate,
+		targetType: "user",
+		targetID:   userID.String(),
+		before:     map[string]string{"tier": user.Tier},
+		after:      map[string]string{"tier": params.Tier},
+	})
+
+	respondWithJSON(w, http.StatusOK, response{
+		ID:    user.ID,
+		Email: user.Email,
+		Tier:  params.Tier,
+	})
+}
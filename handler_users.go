@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -33,14 +34,91 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.CreateUser(database.CreateUserParams{
+	user, err := cfg.db.CreateUserContext(r.Context(), database.CreateUserParams{
 		Email:    params.Email,
 		Password: hashedPassword,
 	})
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, "Email is already registered", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
 
+	cfg.recordAudit(r, auditEntry{
+		actorID:    &user.ID,
+		action:     auditUserCreate,
+		targetType: "user",
+		targetID:   user.ID.String(),
+		after:      user,
+	})
+
 	respondWithJSON(w, http.StatusCreated, user)
 }
+
+// handlerUsersDelete deletes the caller's account once they confirm their
+// password, along with everything they stored
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	type response struct {
+		database.UserDeletion
+		Files deletedFiles `json:"files"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get user", err)
+		return
+	}
+	match, err := auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil || !match {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	files, err := cfg.deleteUserFiles(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete stored files", err)
+		return
+	}
+	deletion, err := cfg.db.DeleteUserContext(r.Context(), userID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't delete user", err)
+		return
+	}
+	cfg.recordAudit(r, auditEntry{
+		actorID:    &userID,
+		action:     auditUserDelete,
+		targetType: "user",
+		targetID:   userID.String(),
+		before:     user,
+	})
+
+	respondWithJSON(w, http.StatusOK, response{
+		UserDeletion: deletion,
+		Files:        files,
+	})
+}
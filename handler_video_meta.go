@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -33,16 +34,35 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	if err := validateTags(params.Tags); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err := validateCategory(params.Category); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	video, err := cfg.db.CreateVideoContext(r.Context(), params.CreateVideoParams)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		respondWithDatabaseError(w, "Couldn't create video", err)
 		return
 	}
 
+	// Pick up chapter timestamps written in the description
+	video.Chapters = parseChapters(video.Description)
+	err = cfg.db.SetChaptersContext(r.Context(), video.ID, video.Chapters)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't save chapters", err)
+		return
+	}
+	cfg.recordAudit(r, videoAudit(userID, auditVideoCreate, video.ID, nil, video))
+
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
+// handlerVideoMetaDelete moves a video to the trash, from where it can be
+// restored until it's purged
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -62,9 +82,9 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.GetVideoContext(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDatabaseError(w, "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
@@ -72,11 +92,14 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.db.TrashVideoContext(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		respondWithDatabaseError(w, "Couldn't delete video", err)
 		return
 	}
+	if trashed, err := cfg.db.GetVideoContext(r.Context(), videoID); err == nil {
+		cfg.recordAudit(r, videoAudit(userID, auditVideoDelete, videoID, video, trashed))
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -89,9 +112,13 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.GetVideoContext(r.Context(), videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDatabaseError(w, "Couldn't get video", err)
+		return
+	}
+	if video.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Video is in the trash", nil)
 		return
 	}
 
@@ -99,6 +126,11 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+	}
+
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
@@ -110,11 +142,26 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params, err := parseListVideosParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = userID
+
+	videos, more, err := cfg.db.ListVideosContext(r.Context(), params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	resp := response{Videos: videos}
+	if more {
+		resp.NextCursor = encodeCursor(videos[len(videos)-1].ID)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
 }
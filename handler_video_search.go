@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// handlerVideoSearch searches the titles and descriptions of the caller's videos
+func (cfg *apiConfig) handlerVideoSearch(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	query := r.URL.Query()
+	params := database.SearchVideosParams{
+		UserID: userID,
+		Query:  query.Get("q"),
+		Limit:  defaultSearchPageSize,
+	}
+	if params.Query == "" {
+		respondWithError(w, http.StatusBadRequest, "q is required", nil)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxSearchPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchPageSize), err)
+			return
+		}
+		params.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must be a non-negative integer", err)
+			return
+		}
+		params.Offset = n
+	}
+
+	results, err := cfg.db.SearchVideosContext(r.Context(), params)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't search videos", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type videoVersionResponse struct {
+	database.VideoVersion
+	Current bool `json:"current"`
+}
+
+// getOwnedVideo loads the video named in the path and checks the caller owns
+// it, responding with an error when they don't
+func (cfg *apiConfig) getOwnedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideoContext(r.Context(), videoID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't access this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// getVideoVersionFromPath loads the version named in the path, checking it
+// belongs to the video
+func (cfg *apiConfig) getVideoVersionFromPath(w http.ResponseWriter, r *http.Request, video database.Video) (database.VideoVersion, bool) {
+	versionID, err := uuid.Parse(r.PathValue("versionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid version ID", err)
+		return database.VideoVersion{}, false
+	}
+	version, err := cfg.db.GetVideoVersionContext(r.Context(), versionID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't get version", err)
+		return database.VideoVersion{}, false
+	}
+	if version.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Version not found", nil)
+		return database.VideoVersion{}, false
+	}
+	return version, true
+}
+
+func isCurrentVersion(video database.Video, version database.VideoVersion) bool {
+	return video.CurrentVersionID != nil && *video.CurrentVersionID == version.ID
+}
+
+func (cfg *apiConfig) handlerVideoVersionsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	versions, err := cfg.db.GetVideoVersionsContext(r.Context(), video.ID)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't retrieve versions", err)
+		return
+	}
+
+	response := []videoVersionResponse{}
+	for _, version := range versions {
+		response = append(response, videoVersionResponse{
+			VideoVersion: version,
+			Current:      isCurrentVersion(video, version),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerVideoVersionRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	version, ok := cfg.getVideoVersionFromPath(w, r, video)
+	if !ok {
+		return
+	}
+
+	before := video
+	video.SetFiles(version.Files)
+	video.CurrentVersionID = &version.ID
+	err := cfg.db.UpdateVideoContext(r.Context(), video)
+	if err != nil {
+		respondWithDatabaseError(w, "Couldn't restore version", err)
+		return
+	}
+	cfg.recordAudit(r, videoAudit(video.UserID, auditVideoUpdate, video.ID, before, video))
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoVersionDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	version, ok := cfg.getVideoVersionFromPath(w, r, video)
+	if !ok {
+		return
+	}
+	if isCurrentVersion(video, version) {
+		respondWithError(w, http.StatusConflict, "Can't delete the current version, restore another one first", nil)
+		return
+	}
+
+	err := cfg.purgeVideoVersion(r.Context(), version)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete version", err)
+		return
+	}
+	cfg.recordAudit(r, videoAudit(video.UserID, auditVideoVersionPurge, video.ID, version, nil))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoVersionsPurge deletes old versions, keeping the current one and
+// the newest ?keep= others
+func (cfg *apiConfig) handlerVideoVersionsPurge(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Purged []database.VideoVersion `json:"purged"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	keep := 0
+	if keepString := r.URL.Query().Get("keep"); keepString != "" {
+		var err error
+		keep, err = strconv.Atoi(keepString)
+		if err != nil || keep < 0 {
+			respondWithError(w, http.StatusBadRequest, "keep must be a non-negative integer", err)
+			return
+		}
+	}
+
+	purged, err := cfg.pruneVideoVersions(r.Context(), video, keep)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't purge versions", err)
+		return
+	}
+	if len(purged) > 0 {
+		cfg.recordAudit(r, videoAudit(video.UserID, auditVideoVersionPurge, video.ID, response{Purged: purged}, nil))
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Purged: purged})
+}
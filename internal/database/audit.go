@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// migrateAuditEvents adds the audit log. Triggers refuse updates and deletes
+// so entries can only ever be appended. Actors aren't foreign keys, so the
+// log outlives deleted accounts.
+func migrateAuditEvents(t *tx) error {
+	statements := []string{}
+	if t.dialect == dialectPostgres {
+		statements = append(statements,
+			`CREATE TABLE audit_events (
+				id UUID PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				actor_id UUID,
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL DEFAULT '',
+				target_id TEXT NOT NULL DEFAULT '',
+				ip TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				diff TEXT
+			)`,
+			`CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_events is append-only';
+			END
+			$$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+		)
+	} else {
+		statements = append(statements,
+			`CREATE TABLE audit_events (
+				id TEXT PRIMARY KEY,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				actor_id TEXT,
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL DEFAULT '',
+				target_id TEXT NOT NULL DEFAULT '',
+				ip TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				diff TEXT
+			)`,
+			`CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events BEGIN
+				SELECT RAISE(ABORT, 'audit_events is append-only');
+			END`,
+			`CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events BEGIN
+				SELECT RAISE(ABORT, 'audit_events is append-only');
+			END`,
+		)
+	}
+	return execAll(t, append(statements,
+		`CREATE INDEX audit_events_created_at ON audit_events(created_at)`,
+		`CREATE INDEX audit_events_actor_id ON audit_events(actor_id, created_at)`,
+		`CREATE INDEX audit_events_action ON audit_events(action, created_at)`,
+	))
+}
+
+func revertAuditEvents(t *tx) error {
+	statements := []string{`DROP TABLE IF EXISTS audit_events`}
+	if t.dialect == dialectPostgres {
+		statements = append(statements, `DROP FUNCTION IF EXISTS audit_events_append_only()`)
+	}
+	return execAll(t, statements)
+}
+
+// AuditEvent records who did what to which target, from where
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Nil when nobody was signed in, e.g. a failed login
+	ActorID    *uuid.UUID `json:"actor_id"`
+	Action     string     `json:"action"`
+	TargetType string     `json:"target_type"`
+	TargetID   string     `json:"target_id"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	// Changed fields as {"field": {"old": ..., "new": ...}}, if any
+	Diff json.RawMessage `json:"diff,omitempty"`
+}
+
+const auditEventColumns = `
+	id,
+	created_at,
+	actor_id,
+	action,
+	target_type,
+	target_id,
+	ip,
+	user_agent,
+	diff
+`
+
+func scanAuditEvent(row interface{ Scan(...any) error }) (AuditEvent, error) {
+	var event AuditEvent
+	var diff *string
+	err := row.Scan(
+		&event.ID,
+		&event.CreatedAt,
+		&event.ActorID,
+		&event.Action,
+		&event.TargetType,
+		&event.TargetID,
+		&event.IP,
+		&event.UserAgent,
+		&diff,
+	)
+	if diff != nil {
+		event.Diff = json.RawMessage(*diff)
+	}
+	return event, err
+}
+
+// RecordAuditEvent appends an event to the audit log. Its ID and time are
+// filled in here.
+func (c Client) RecordAuditEvent(event AuditEvent) error {
+	return c.RecordAuditEventContext(context.Background(), event)
+}
+
+func (c Client) RecordAuditEventContext(ctx context.Context, event AuditEvent) error {
+	query := `
+	INSERT INTO audit_events (
+		id,
+		created_at,
+		actor_id,
+		action,
+		target_type,
+		target_id,
+		ip,
+		user_agent,
+		diff
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	var diff *string
+	if len(event.Diff) > 0 {
+		s := string(event.Diff)
+		diff = &s
+	}
+	_, err := c.db.ExecContext(
+		ctx,
+		query,
+		uuid.New(),
+		event.ActorID,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		event.IP,
+		event.UserAgent,
+		diff,
+	)
+	return err
+}
+
+type AuditEventsParams struct {
+	// Optional filters
+	ActorID *uuid.UUID
+	Action  string
+	Since   *time.Time
+	Until   *time.Time
+	// Oldest first instead of newest first
+	Ascending bool
+	// Zero for every matching event
+	Limit int
+	// The last event of the previous page, nil for the first page
+	After *uuid.UUID
+}
+
+// GetAuditEvents returns one page of the audit log and whether more follow
+func (c Client) GetAuditEvents(params AuditEventsParams) ([]AuditEvent, bool, error) {
+	return c.GetAuditEventsContext(context.Background(), params)
+}
+
+func (c Client) GetAuditEventsContext(ctx context.Context, params AuditEventsParams) ([]AuditEvent, bool, error) {
+	limit := params.Limit
+	if limit > 0 {
+		// One extra row tells whether there's another page
+		params.Limit++
+	}
+	events := []AuditEvent{}
+	err := c.EachAuditEventContext(ctx, params, func(event AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if limit > 0 && len(events) > limit {
+		return events[:limit], true, nil
+	}
+	return events, false, nil
+}
+
+// EachAuditEvent calls fn with every matching event in turn without holding
+// them all in memory, stopping at the first error
+func (c Client) EachAuditEvent(params AuditEventsParams, fn func(AuditEvent) error) error {
+	return c.EachAuditEventContext(context.Background(), params, fn)
+}
+
+func (c Client) EachAuditEventContext(ctx context.Context, params AuditEventsParams, fn func(AuditEvent) error) error {
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if params.ActorID != nil {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, *params.ActorID)
+	}
+	if params.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, params.Action)
+	}
+	if params.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, c.db.dialect.timeArg(*params.Since))
+	}
+	if params.Until != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, c.db.dialect.timeArg(*params.Until))
+	}
+
+	direction, comparison := "DESC", "<"
+	if params.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+	if params.After != nil {
+		var count int
+		err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events WHERE id = ?", *params.After).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrInvalidCursor
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"(created_at, id) %s (SELECT created_at, id FROM audit_events WHERE id = ?)", comparison,
+		))
+		args = append(args, *params.After)
+	}
+
+	query := `
+	SELECT ` + auditEventColumns + `
+	FROM audit_events
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY created_at ` + direction + `, id ` + direction
+	if params.Limit > 0 {
+		query += `
+	LIMIT ?`
+		args = append(args, params.Limit)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+type Chapter struct {
+	Start float64 `json:"start"` // Seconds from the start of the video
+	Title string  `json:"title"`
+}
+
+func (c Client) GetChapters(videoID uuid.UUID) ([]Chapter, error) {
+	return c.GetChaptersContext(context.Background(), videoID)
+}
+
+func (c Client) GetChaptersContext(ctx context.Context, videoID uuid.UUID) ([]Chapter, error) {
+	query := `
+	SELECT start_seconds, title
+	FROM video_chapters
+	WHERE video_id = ?
+	ORDER BY position
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		var chapter Chapter
+		if err := rows.Scan(&chapter.Start, &chapter.Title); err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	return chapters, rows.Err()
+}
+
+// SetChapters replaces all the chapters of a video
+func (c Client) SetChapters(videoID uuid.UUID, chapters []Chapter) error {
+	return c.SetChaptersContext(context.Background(), videoID, chapters)
+}
+
+func (c Client) SetChaptersContext(ctx context.Context, videoID uuid.UUID, chapters []Chapter) error {
+	tx, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_chapters WHERE video_id = ?", videoID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO video_chapters (
+		video_id,
+		position,
+		start_seconds,
+		title
+	) VALUES (?, ?, ?, ?)
+	`
+	for i, chapter := range chapters {
+		_, err = tx.Exec(query, videoID, i, chapter.Start, chapter.Title)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
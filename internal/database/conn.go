@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// conn wraps the connection pool so queries written with ? placeholders run
+// on every backend, each within the query timeout
+type conn struct {
+	*sql.DB
+	dialect dialect
+	// Zero for no timeout
+	queryTimeout time.Duration
+}
+
+type noQueryTimeoutKey struct{}
+
+// WithoutQueryTimeout marks a context whose statements may run for longer
+// than the query timeout, e.g. to stream a large export
+func WithoutQueryTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noQueryTimeoutKey{}, true)
+}
+
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 || ctx.Value(noQueryTimeoutKey{}) != nil {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// rows releases its statement's timeout once closed
+type rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+// row releases its statement's timeout once scanned
+type row struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+func (r *row) Scan(dest ...any) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx, c.queryTimeout)
+	defer cancel()
+	return c.DB.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...any) (*rows, error) {
+	ctx, cancel := withQueryTimeout(ctx, c.queryTimeout)
+	sqlRows, err := c.DB.QueryContext(ctx, c.dialect.rebind(query), args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &rows{Rows: sqlRows, cancel: cancel}, nil
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...any) *row {
+	ctx, cancel := withQueryTimeout(ctx, c.queryTimeout)
+	return &row{Row: c.DB.QueryRowContext(ctx, c.dialect.rebind(query), args...), cancel: cancel}
+}
+
+// BeginContext starts a transaction whose statements all run with ctx. The
+// query timeout applies to each statement rather than the whole transaction.
+func (c *conn) BeginContext(ctx context.Context) (*tx, error) {
+	sqlTx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: sqlTx, dialect: c.dialect, ctx: ctx, queryTimeout: c.queryTimeout}, nil
+}
+
+// tx is the transaction counterpart of conn. Its statements run with the
+// context it was started with.
+type tx struct {
+	*sql.Tx
+	dialect      dialect
+	ctx          context.Context
+	queryTimeout time.Duration
+}
+
+func (t *tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.ExecContext(t.ctx, query, args...)
+}
+
+func (t *tx) Query(query string, args ...any) (*rows, error) {
+	return t.QueryContext(t.ctx, query, args...)
+}
+
+func (t *tx) QueryRow(query string, args ...any) *row {
+	return t.QueryRowContext(t.ctx, query, args...)
+}
+
+func (t *tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx, t.queryTimeout)
+	defer cancel()
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryContext(ctx context.Context, query string, args ...any) (*rows, error) {
+	ctx, cancel := withQueryTimeout(ctx, t.queryTimeout)
+	sqlRows, err := t.Tx.QueryContext(ctx, t.dialect.rebind(query), args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &rows{Rows: sqlRows, cancel: cancel}, nil
+}
+
+func (t *tx) QueryRowContext(ctx context.Context, query string, args ...any) *row {
+	ctx, cancel := withQueryTimeout(ctx, t.queryTimeout)
+	return &row{Row: t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), args...), cancel: cancel}
+}
+
+// timeArg formats a time the way the backend stores CURRENT_TIMESTAMP, so
+// comparisons with timestamp columns work on SQLite's text timestamps too
+func (d dialect) timeArg(t time.Time) any {
+	if d == dialectSQLite {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	}
+	return t
+}
+
+// rebind turns ? placeholders into the $1, $2, ... form Postgres expects,
+// leaving question marks inside string literals alone
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
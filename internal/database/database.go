@@ -1,23 +1,57 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Applied to every SQLite connection. Foreign keys are only enforced when
+// each connection asks for them. WAL lets reads carry on during a write, and
+// writers wait for each other instead of failing with "database is locked":
+// immediate transactions take the write lock up front, where busy_timeout
+// applies, rather than failing when a read lock can't be upgraded.
+var sqliteConnectionOptions = []string{
+	"_foreign_keys=on",
+	"_journal_mode=WAL",
+	"_synchronous=NORMAL",
+	"_busy_timeout=5000",
+	"_txlock=immediate",
+}
+
+// Client is the app's database. Every method has a ...Context variant that
+// runs its queries with a context, each statement within the query timeout.
+// The plain methods use context.Background().
 type Client struct {
-	db *sql.DB
+	db *conn
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+// NewClient connects to the database at dbURL. postgres:// and postgresql://
+// URLs select Postgres; anything else is a SQLite file path, optionally
+// prefixed with sqlite://.
+func NewClient(dbURL string) (Client, error) {
+	driver, dsn, d := "sqlite3", strings.TrimPrefix(dbURL, "sqlite://"), dialectSQLite
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		driver, dsn, d = "postgres", dbURL, dialectPostgres
+	} else {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + strings.Join(sqliteConnectionOptions, "&")
+	}
+
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	c := Client{&conn{DB: db, dialect: d}}
+	err = c.ensureMigrationsTable(context.Background())
 	if err != nil {
 		return Client{}, err
 	}
@@ -25,65 +59,35 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
-	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
-	if err != nil {
-		return err
-	}
-
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
-	}
-	return nil
+// SetQueryTimeout bounds how long each statement may run, zero for no limit.
+// Set it before the client is shared.
+func (c Client) SetQueryTimeout(timeout time.Duration) {
+	c.db.queryTimeout = timeout
 }
 
 func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
-		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
-	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+	return c.ResetContext(context.Background())
+}
+
+func (c Client) ResetContext(ctx context.Context) error {
+	// Children first so foreign keys hold on backends that enforce them
+	tables := []string{
+		"refresh_tokens",
+		"stored_objects",
+		"video_fingerprints",
+		"video_versions",
+		"video_chapters",
+		"video_tags",
+		"playlist_items",
+		"playlists",
+		"videos",
+		"tags",
+		"users",
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
-		return fmt.Errorf("failed to reset table videos: %w", err)
+	for _, table := range tables {
+		if _, err := c.db.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return fmt.Errorf("failed to reset table %s: %w", table, err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound is returned when the row being read, updated or deleted
+	// doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write clashes with a row that must be
+	// unique, such as a second user with the same email
+	ErrConflict = errors.New("conflict")
+)
+
+// notFound wraps ErrNotFound with what was missing, e.g. "video 1234: not found"
+func notFound(kind string, id any) error {
+	return fmt.Errorf("%s %v: %w", kind, id, ErrNotFound)
+}
+
+// isUniqueViolation reports whether err is either backend refusing a
+// duplicate value in a unique column
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
+
+// requireRow turns an update or delete of a single row that matched nothing
+// into ErrNotFound
+func requireRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
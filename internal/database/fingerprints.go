@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Fingerprint is a perceptual hash of a video: the dHash of frames sampled at
+// evenly spaced points, plus the video's duration
+type Fingerprint struct {
+	Hashes   []uint64
+	Duration float64
+}
+
+type VideoFingerprint struct {
+	VideoID uuid.UUID
+	Fingerprint
+}
+
+func encodeHashes(hashes []uint64) string {
+	parts := make([]string, len(hashes))
+	for i, hash := range hashes {
+		parts[i] = fmt.Sprintf("%016x", hash)
+	}
+	return strings.Join(parts, ",")
+}
+
+func decodeHashes(s string) ([]uint64, error) {
+	hashes := []uint64{}
+	if s == "" {
+		return hashes, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		hash, err := strconv.ParseUint(part, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fingerprint hash %q: %w", part, err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+func (c Client) SetFingerprint(videoID uuid.UUID, fingerprint Fingerprint) error {
+	return c.SetFingerprintContext(context.Background(), videoID, fingerprint)
+}
+
+func (c Client) SetFingerprintContext(ctx context.Context, videoID uuid.UUID, fingerprint Fingerprint) error {
+	query := `
+	INSERT INTO video_fingerprints (video_id, hashes, duration)
+	VALUES (?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		hashes = excluded.hashes,
+		duration = excluded.duration
+	`
+	_, err := c.db.ExecContext(ctx, query, videoID, encodeHashes(fingerprint.Hashes), fingerprint.Duration)
+	return err
+}
+
+// GetFingerprint returns ErrNotFound when the video hasn't been fingerprinted
+func (c Client) GetFingerprint(videoID uuid.UUID) (*Fingerprint, error) {
+	return c.GetFingerprintContext(context.Background(), videoID)
+}
+
+func (c Client) GetFingerprintContext(ctx context.Context, videoID uuid.UUID) (*Fingerprint, error) {
+	query := `
+	SELECT hashes, duration
+	FROM video_fingerprints
+	WHERE video_id = ?
+	`
+	var hashes string
+	var fingerprint Fingerprint
+	err := c.db.QueryRowContext(ctx, query, videoID).Scan(&hashes, &fingerprint.Duration)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound("fingerprint of video", videoID)
+		}
+		return nil, err
+	}
+	fingerprint.Hashes, err = decodeHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+	return &fingerprint, nil
+}
+
+// GetUserFingerprints returns the fingerprints of every video a user owns,
+// leaving out the trash
+func (c Client) GetUserFingerprints(userID uuid.UUID) ([]VideoFingerprint, error) {
+	return c.GetUserFingerprintsContext(context.Background(), userID)
+}
+
+func (c Client) GetUserFingerprintsContext(ctx context.Context, userID uuid.UUID) ([]VideoFingerprint, error) {
+	query := `
+	SELECT f.video_id, f.hashes, f.duration
+	FROM video_fingerprints f
+	JOIN videos v ON v.id = f.video_id
+	WHERE v.user_id = ? AND v.deleted_at IS NULL
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fingerprints := []VideoFingerprint{}
+	for rows.Next() {
+		var fingerprint VideoFingerprint
+		var hashes string
+		if err := rows.Scan(&fingerprint.VideoID, &hashes, &fingerprint.Duration); err != nil {
+			return nil, err
+		}
+		fingerprint.Hashes, err = decodeHashes(hashes)
+		if err != nil {
+			return nil, err
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints, rows.Err()
+}
@@ -0,0 +1,404 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// migration is one step of the schema's history. up and down each run inside
+// a transaction; down is nil when a migration can't be reverted.
+type migration struct {
+	version int
+	name    string
+	up      func(t *tx) error
+	down    func(t *tx) error
+	// SQLite can only rebuild a table others refer to with foreign keys off.
+	// They're checked again before the migration commits.
+	foreignKeysOff bool
+}
+
+// migrations must stay in version order, and released ones must never change
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up:      migrateBaseline,
+		down:    dropTables("stored_objects", "video_fingerprints", "video_versions", "video_chapters", "videos", "refresh_tokens", "users"),
+	},
+	{
+		version:        2,
+		name:           "enforce foreign keys",
+		up:             migrateForeignKeys,
+		foreignKeysOff: true,
+	},
+	{
+		version: 3,
+		name:    "video search",
+		up:      migrateVideoSearch,
+		down:    revertVideoSearch,
+	},
+	{
+		version: 4,
+		name:    "video tags and categories",
+		up:      migrateVideoTags,
+		down:    revertVideoTags,
+	},
+	{
+		version: 5,
+		name:    "playlists",
+		up:      migratePlaylists,
+		down:    dropTables("playlist_items", "playlists"),
+	},
+	{
+		version: 6,
+		name:    "soft delete",
+		up:      migrateSoftDelete,
+		down:    revertSoftDelete,
+	},
+	{
+		version: 7,
+		name:    "audit events",
+		up:      migrateAuditEvents,
+		down:    revertAuditEvents,
+	},
+}
+
+// migrateBaseline creates the schema as it was when migrations were
+// introduced. Tables that already exist are kept, so SQLite databases from
+// before then are adopted as they are, gaining only the columns they lack.
+func migrateBaseline(t *tx) error {
+	if t.dialect == dialectPostgres {
+		return execAll(t, postgresSchema)
+	}
+	if err := execAll(t, sqliteSchema); err != nil {
+		return err
+	}
+	for _, column := range sqliteLegacyColumns {
+		if err := t.addColumnIfMissing(column.table, column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rows that refer to users or videos that no longer exist, which
+// migrateForeignKeys won't migrate
+var orphanedRows = []struct {
+	kind  string
+	query string
+}{
+	{"refresh tokens", `SELECT COUNT(*) FROM refresh_tokens WHERE user_id NOT IN (SELECT id FROM users)`},
+	{"videos", `SELECT COUNT(*) FROM videos WHERE user_id IS NULL OR user_id NOT IN (SELECT id FROM users)`},
+	{"video chapters", `SELECT COUNT(*) FROM video_chapters WHERE video_id NOT IN (SELECT id FROM videos)`},
+	{"video fingerprints", `SELECT COUNT(*) FROM video_fingerprints WHERE video_id NOT IN (SELECT id FROM videos)`},
+	{"video versions", `SELECT COUNT(*) FROM video_versions WHERE video_id NOT IN (SELECT id FROM videos)
+		OR uploader_id NOT IN (SELECT id FROM users)`},
+}
+
+// migrateForeignKeys gets SQLite databases ready for foreign key enforcement:
+// videos.user_id becomes a TEXT column like the IDs it refers to. Rows left
+// behind by deleted users and videos fail the migration rather than being
+// dropped, so they can be reassigned or deleted by hand first. Postgres always
+// enforced its foreign keys, so there's nothing to do there.
+func migrateForeignKeys(t *tx) error {
+	if t.dialect == dialectPostgres {
+		return nil
+	}
+	orphans := []string{}
+	for _, o := range orphanedRows {
+		var count int
+		if err := t.QueryRow(o.query).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			orphans = append(orphans, fmt.Sprintf("%d %s", count, o.kind))
+		}
+	}
+	if len(orphans) > 0 {
+		return fmt.Errorf("found rows whose user or video no longer exists (%s), reassign or delete them and migrate again",
+			strings.Join(orphans, ", "))
+	}
+	return execAll(t, []string{
+		`CREATE TABLE videos_new (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			title TEXT NOT NULL,
+			description TEXT,
+			thumbnail_url TEXT,
+			thumbnail_srcset TEXT,
+			video_url TEXT,
+			vertical_video_url TEXT,
+			preview_url TEXT,
+			preview_mp4_url TEXT,
+			duration REAL,
+			audio_url TEXT,
+			audio_size INTEGER,
+			audio_duration REAL,
+			audio_mp3_url TEXT,
+			original_key TEXT,
+			vertical_mode TEXT,
+			pipeline_version INTEGER,
+			current_version_id TEXT,
+			user_id TEXT NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`INSERT INTO videos_new (` + foreignKeysVideoColumns + `)
+			SELECT ` + foreignKeysVideoColumns + ` FROM videos`,
+		`DROP TABLE videos`,
+		`ALTER TABLE videos_new RENAME TO videos`,
+		`CREATE INDEX videos_user_id ON videos(user_id)`,
+	})
+}
+
+// The videos columns as of migrateForeignKeys, kept apart from videoColumns
+// so later columns don't change what it copies
+const foreignKeysVideoColumns = `
+	id, created_at, updated_at, title, description, thumbnail_url,
+	thumbnail_srcset, video_url, vertical_video_url, preview_url,
+	preview_mp4_url, duration, audio_url, audio_size, audio_duration,
+	audio_mp3_url, original_key, vertical_mode, pipeline_version,
+	current_version_id, user_id
+`
+
+func dropTables(tables ...string) func(t *tx) error {
+	return func(t *tx) error {
+		for _, table := range tables {
+			if _, err := t.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func execAll(t *tx, statements []string) error {
+	for _, statement := range statements {
+		if _, err := t.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrationStatus describes a migration and when it was applied, if it was
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// LatestMigration is the version the schema reaches once every migration is applied
+func LatestMigration() int {
+	return migrations[len(migrations)-1].version
+}
+
+func (c Client) ensureMigrationsTable(ctx context.Context) error {
+	timestamp := "TIMESTAMP"
+	if c.db.dialect == dialectPostgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := c.db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at `+timestamp+` DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// MigrationStatus lists every known migration along with when it was applied
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	return c.MigrationStatusContext(context.Background())
+}
+
+func (c Client) MigrationStatusContext(ctx context.Context) ([]MigrationStatus, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// SchemaVersion returns the version of the newest applied migration, 0 for
+// an empty database
+func (c Client) SchemaVersion() (int, error) {
+	return c.SchemaVersionContext(context.Background())
+}
+
+func (c Client) SchemaVersionContext(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := c.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// Migrate applies every pending migration and returns the ones it applied
+func (c Client) Migrate() ([]MigrationStatus, error) {
+	return c.MigrateContext(context.Background())
+}
+
+func (c Client) MigrateContext(ctx context.Context) ([]MigrationStatus, error) {
+	return c.MigrateToContext(ctx, LatestMigration())
+}
+
+// MigrateTo applies or reverts migrations, one transaction each, until the
+// schema is at version target. It returns the migrations it ran in the order
+// it ran them.
+func (c Client) MigrateTo(target int) ([]MigrationStatus, error) {
+	return c.MigrateToContext(context.Background(), target)
+}
+
+func (c Client) MigrateToContext(ctx context.Context, target int) ([]MigrationStatus, error) {
+	// Rebuilding a large table can take much longer than any query should
+	ctx = WithoutQueryTimeout(ctx)
+	if target < 0 || target > LatestMigration() {
+		return nil, fmt.Errorf("unknown schema version %d", target)
+	}
+	current, err := c.SchemaVersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ran := []MigrationStatus{}
+	if target >= current {
+		for _, m := range migrations {
+			if m.version <= current || m.version > target {
+				continue
+			}
+			if err := c.runMigration(ctx, m, true); err != nil {
+				return ran, err
+			}
+			ran = append(ran, MigrationStatus{Version: m.version, Name: m.name})
+		}
+		return ran, nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		if m.down == nil {
+			return ran, fmt.Errorf("migration %d (%s) can't be reverted", m.version, m.name)
+		}
+		if err := c.runMigration(ctx, m, false); err != nil {
+			return ran, err
+		}
+		ran = append(ran, MigrationStatus{Version: m.version, Name: m.name})
+	}
+	return ran, nil
+}
+
+func (c Client) runMigration(ctx context.Context, m migration, up bool) error {
+	var t *tx
+	var err error
+	if m.foreignKeysOff && c.db.dialect == dialectSQLite {
+		// The pragma is per connection and ignored inside a transaction
+		sqlConn, err := c.db.DB.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer sqlConn.Close()
+		if _, err := sqlConn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		// Restored even when ctx is cancelled, so the connection goes back to the pool as it was
+		defer sqlConn.ExecContext(context.WithoutCancel(ctx), "PRAGMA foreign_keys = ON")
+		sqlTx, err := sqlConn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		t = &tx{Tx: sqlTx, dialect: c.db.dialect, ctx: ctx, queryTimeout: c.db.queryTimeout}
+	} else {
+		t, err = c.db.BeginContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	defer t.Rollback()
+
+	// Instances starting together on Postgres take turns, and each skips
+	// what another has already done
+	if t.dialect == dialectPostgres {
+		if _, err := t.Exec("SELECT pg_advisory_xact_lock(7362821)"); err != nil {
+			return err
+		}
+	}
+	var applied int
+	err = t.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", m.version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if (applied > 0) == up {
+		return nil
+	}
+
+	if up {
+		err = m.up(t)
+		if err == nil {
+			_, err = t.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name)
+		}
+	} else {
+		err = m.down(t)
+		if err == nil {
+			_, err = t.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version)
+		}
+	}
+	if err == nil && m.foreignKeysOff && t.dialect == dialectSQLite {
+		err = t.checkForeignKeys()
+	}
+	if err != nil {
+		direction := "applying"
+		if !up {
+			direction = "reverting"
+		}
+		return fmt.Errorf("%s migration %d (%s): %w", direction, m.version, m.name, err)
+	}
+	return t.Commit()
+}
+
+// ErrPendingMigrations is returned by CheckSchema when the schema is behind
+var ErrPendingMigrations = errors.New("database has pending migrations")
+
+// CheckSchema makes sure every migration has been applied
+func (c Client) CheckSchema() error {
+	return c.CheckSchemaContext(context.Background())
+}
+
+func (c Client) CheckSchemaContext(ctx context.Context) error {
+	version, err := c.SchemaVersionContext(ctx)
+	if err != nil {
+		return err
+	}
+	if version < LatestMigration() {
+		return fmt.Errorf("%w: at version %d of %d", ErrPendingMigrations, version, LatestMigration())
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// migratePlaylists adds playlists and their ordered items. A video can only
+// be in a playlist once.
+func migratePlaylists(t *tx) error {
+	idType, timeType := "TEXT", "TIMESTAMP"
+	if t.dialect == dialectPostgres {
+		idType, timeType = "UUID", "TIMESTAMPTZ"
+	}
+	return execAll(t, []string{
+		`CREATE TABLE playlists (
+			id ` + idType + ` PRIMARY KEY,
+			created_at ` + timeType + ` DEFAULT CURRENT_TIMESTAMP,
+			updated_at ` + timeType + ` DEFAULT CURRENT_TIMESTAMP,
+			user_id ` + idType + ` NOT NULL REFERENCES users(id),
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			visibility TEXT NOT NULL DEFAULT 'private'
+		)`,
+		`CREATE INDEX playlists_user_id ON playlists(user_id)`,
+		`CREATE TABLE playlist_items (
+			playlist_id ` + idType + ` NOT NULL REFERENCES playlists(id),
+			video_id ` + idType + ` NOT NULL REFERENCES videos(id),
+			position INTEGER NOT NULL,
+			PRIMARY KEY (playlist_id, video_id)
+		)`,
+		`CREATE INDEX playlist_items_video_id ON playlist_items(video_id)`,
+	})
+}
+
+// ErrPlaylistMismatch is returned when a reorder doesn't list exactly the
+// videos already in the playlist
+var ErrPlaylistMismatch = errors.New("videos don't match the playlist")
+
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// The playlist's videos in order
+	VideoIDs []uuid.UUID `json:"video_ids"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	// One of private, unlisted or public
+	Visibility string `json:"visibility"`
+}
+
+const playlistColumns = `
+	id,
+	created_at,
+	updated_at,
+	user_id,
+	title,
+	description,
+	visibility
+`
+
+func scanPlaylist(row interface{ Scan(...any) error }) (Playlist, error) {
+	var playlist Playlist
+	err := row.Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.UserID,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.Visibility,
+	)
+	return playlist, err
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	return c.CreatePlaylistContext(context.Background(), params)
+}
+
+func (c Client) CreatePlaylistContext(ctx context.Context, params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		title,
+		description,
+		visibility
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, id, params.UserID, params.Title, params.Description, params.Visibility)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylistContext(ctx, id)
+}
+
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	return c.GetPlaylistContext(context.Background(), id)
+}
+
+func (c Client) GetPlaylistContext(ctx context.Context, id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT ` + playlistColumns + `
+	FROM playlists
+	WHERE id = ?
+	`
+	playlist, err := scanPlaylist(c.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, notFound("playlist", id)
+		}
+		return Playlist{}, err
+	}
+
+	playlist.VideoIDs, err = playlistVideoIDs(ctx, c.db, id)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return playlist, nil
+}
+
+// GetPlaylists returns a user's playlists, most recently updated first
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	return c.GetPlaylistsContext(context.Background(), userID)
+}
+
+func (c Client) GetPlaylistsContext(ctx context.Context, userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT ` + playlistColumns + `
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY updated_at DESC, id
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range playlists {
+		playlists[i].VideoIDs, err = playlistVideoIDs(ctx, c.db, playlists[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return playlists, nil
+}
+
+// querier is what conn and tx have in common
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*rows, error)
+}
+
+func playlistVideoIDs(ctx context.Context, q querier, playlistID uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+	SELECT playlist_items.video_id
+	FROM playlist_items
+	JOIN videos ON videos.id = playlist_items.video_id
+	WHERE playlist_items.playlist_id = ? AND videos.deleted_at IS NULL
+	ORDER BY playlist_items.position
+	`
+	rows, err := q.QueryContext(ctx, query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPlaylistVideos returns the videos of a playlist in order. Videos in the
+// trash keep their place but aren't shown until they're restored.
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	return c.GetPlaylistVideosContext(context.Background(), playlistID)
+}
+
+func (c Client) GetPlaylistVideosContext(ctx context.Context, playlistID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT ` + prefixColumns("v", videoColumns) + `
+	FROM playlist_items
+	JOIN videos v ON v.id = playlist_items.video_id
+	WHERE playlist_items.playlist_id = ? AND v.deleted_at IS NULL
+	ORDER BY playlist_items.position
+	`
+	return c.queryVideos(ctx, query, playlistID)
+}
+
+// UpdatePlaylist saves a playlist's title, description and visibility
+func (c Client) UpdatePlaylist(playlist Playlist) error {
+	return c.UpdatePlaylistContext(context.Background(), playlist)
+}
+
+func (c Client) UpdatePlaylistContext(ctx context.Context, playlist Playlist) error {
+	query := `
+	UPDATE playlists
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		title = ?,
+		description = ?,
+		visibility = ?
+	WHERE id = ?
+	`
+	return requireRow(c.db.ExecContext(ctx, query, playlist.Title, playlist.Description, playlist.Visibility, playlist.ID))
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	return c.DeletePlaylistContext(context.Background(), id)
+}
+
+func (c Client) DeletePlaylistContext(ctx context.Context, id uuid.UUID) error {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	_, err = t.Exec("DELETE FROM playlist_items WHERE playlist_id = ?", id)
+	if err != nil {
+		return err
+	}
+	err = requireRow(t.Exec("DELETE FROM playlists WHERE id = ?", id))
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// AddPlaylistVideo appends a video to a playlist. Adding a video that's
+// already there leaves it where it is.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID) error {
+	return c.AddPlaylistVideoContext(context.Background(), playlistID, videoID)
+}
+
+func (c Client) AddPlaylistVideoContext(ctx context.Context, playlistID, videoID uuid.UUID) error {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	query := `
+	INSERT INTO playlist_items (playlist_id, video_id, position)
+	VALUES (?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM playlist_items WHERE playlist_id = ?))
+	ON CONFLICT (playlist_id, video_id) DO NOTHING
+	`
+	_, err = t.Exec(query, playlistID, videoID, playlistID)
+	if err != nil {
+		return err
+	}
+	if err := touchPlaylist(t, playlistID); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// RemovePlaylistVideo takes a video out of a playlist, keeping the order of
+// the rest
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) error {
+	return c.RemovePlaylistVideoContext(context.Background(), playlistID, videoID)
+}
+
+func (c Client) RemovePlaylistVideoContext(ctx context.Context, playlistID, videoID uuid.UUID) error {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	_, err = t.Exec("DELETE FROM playlist_items WHERE playlist_id = ? AND video_id = ?", playlistID, videoID)
+	if err != nil {
+		return err
+	}
+	if err := touchPlaylist(t, playlistID); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// ReorderPlaylist puts a playlist's videos in the given order. videoIDs must
+// hold every video in the playlist outside the trash exactly once, otherwise
+// nothing changes and ErrPlaylistMismatch is returned.
+func (c Client) ReorderPlaylist(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	return c.ReorderPlaylistContext(context.Background(), playlistID, videoIDs)
+}
+
+func (c Client) ReorderPlaylistContext(ctx context.Context, playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	current, err := playlistVideoIDs(ctx, t, playlistID)
+	if err != nil {
+		return err
+	}
+	if len(current) != len(videoIDs) {
+		return ErrPlaylistMismatch
+	}
+	remaining := map[uuid.UUID]bool{}
+	for _, id := range current {
+		remaining[id] = true
+	}
+	for _, id := range videoIDs {
+		if !remaining[id] {
+			return ErrPlaylistMismatch
+		}
+		delete(remaining, id)
+	}
+
+	// Videos in the trash keep their slots, the others fill the rest in the
+	// new order, so every video ends up with a position of its own
+	query := `
+	SELECT playlist_items.video_id, videos.deleted_at IS NOT NULL
+	FROM playlist_items
+	JOIN videos ON videos.id = playlist_items.video_id
+	WHERE playlist_items.playlist_id = ?
+	ORDER BY playlist_items.position
+	`
+	rows, err := t.Query(query, playlistID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	order := []uuid.UUID{}
+	next := 0
+	for rows.Next() {
+		var id uuid.UUID
+		var trashed bool
+		if err := rows.Scan(&id, &trashed); err != nil {
+			return err
+		}
+		if !trashed {
+			id = videoIDs[next]
+			next++
+		}
+		order = append(order, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for i, id := range order {
+		_, err = t.Exec("UPDATE playlist_items SET position = ? WHERE playlist_id = ? AND video_id = ?", i, playlistID, id)
+		if err != nil {
+			return err
+		}
+	}
+	if err := touchPlaylist(t, playlistID); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+func touchPlaylist(t *tx, playlistID uuid.UUID) error {
+	_, err := t.Exec("UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", playlistID)
+	return err
+}
@@ -0,0 +1,81 @@
+package database
+
+// postgresSchema mirrors sqliteSchema with native UUID, timestamp and double
+// precision types
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id UUID PRIMARY KEY,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		password TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL,
+		tier TEXT NOT NULL DEFAULT 'free'
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token TEXT PRIMARY KEY,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMPTZ,
+		user_id UUID NOT NULL REFERENCES users(id),
+		expires_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS videos (
+		id UUID PRIMARY KEY,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		thumbnail_url TEXT,
+		thumbnail_srcset TEXT,
+		video_url TEXT,
+		vertical_video_url TEXT,
+		preview_url TEXT,
+		preview_mp4_url TEXT,
+		duration DOUBLE PRECISION,
+		audio_url TEXT,
+		audio_size BIGINT,
+		audio_duration DOUBLE PRECISION,
+		audio_mp3_url TEXT,
+		original_key TEXT,
+		vertical_mode TEXT,
+		pipeline_version INTEGER,
+		current_version_id UUID,
+		user_id UUID REFERENCES users(id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS videos_user_id ON videos(user_id)`,
+	`CREATE TABLE IF NOT EXISTS video_chapters (
+		video_id UUID NOT NULL REFERENCES videos(id),
+		position INTEGER NOT NULL,
+		start_seconds DOUBLE PRECISION NOT NULL,
+		title TEXT NOT NULL,
+		PRIMARY KEY(video_id, position)
+	)`,
+	`CREATE TABLE IF NOT EXISTS video_versions (
+		id UUID PRIMARY KEY,
+		video_id UUID NOT NULL REFERENCES videos(id),
+		version INTEGER NOT NULL,
+		object_key TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		metadata TEXT NOT NULL,
+		uploaded_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		uploader_id UUID NOT NULL REFERENCES users(id),
+		UNIQUE(video_id, version)
+	)`,
+	`CREATE TABLE IF NOT EXISTS video_fingerprints (
+		video_id UUID PRIMARY KEY REFERENCES videos(id),
+		hashes TEXT NOT NULL,
+		duration DOUBLE PRECISION NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS stored_objects (
+		location TEXT NOT NULL,
+		key TEXT NOT NULL,
+		user_id UUID NOT NULL,
+		video_id UUID NOT NULL,
+		category TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(location, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS stored_objects_user_id ON stored_objects(user_id)`,
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,6 +23,10 @@ type CreateRefreshTokenParams struct {
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	return c.CreateRefreshTokenContext(context.Background(), params)
+}
+
+func (c Client) CreateRefreshTokenContext(ctx context.Context, params CreateRefreshTokenParams) (RefreshToken, error) {
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
@@ -30,25 +36,32 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 			expires_at
 		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.ExecContext(ctx, query, params.Token, params.UserID.String(), params.ExpiresAt)
 	if err != nil {
 		return RefreshToken{}, err
 	}
 
-	return c.GetRefreshToken(params.Token)
+	return c.GetRefreshTokenContext(ctx, params.Token)
 }
 
 func (c Client) RevokeRefreshToken(token string) error {
+	return c.RevokeRefreshTokenContext(context.Background(), token)
+}
+
+func (c Client) RevokeRefreshTokenContext(ctx context.Context, token string) error {
 	query := `
 		UPDATE refresh_tokens
 		SET revoked_at = CURRENT_TIMESTAMP
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
-	return err
+	return requireRow(c.db.ExecContext(ctx, query, token))
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
+	return c.GetRefreshTokenContext(context.Background(), token)
+}
+
+func (c Client) GetRefreshTokenContext(ctx context.Context, token string) (RefreshToken, error) {
 	query := `
 		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
 		FROM refresh_tokens
@@ -56,11 +69,11 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
+	err := c.db.QueryRowContext(ctx, query, token).
 		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return RefreshToken{}, nil
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, ErrNotFound
 		}
 		return RefreshToken{}, err
 	}
@@ -74,10 +87,13 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 }
 
 func (c Client) DeleteRefreshToken(token string) error {
+	return c.DeleteRefreshTokenContext(context.Background(), token)
+}
+
+func (c Client) DeleteRefreshTokenContext(ctx context.Context, token string) error {
 	query := `
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
-	return err
+	return requireRow(c.db.ExecContext(ctx, query, token))
 }
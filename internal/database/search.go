@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// migrateVideoSearch indexes video titles and descriptions for full-text
+// search. Postgres gets a generated tsvector column. SQLite gets an FTS5
+// table that reads its text from videos, keyed by videos.rowid, with triggers
+// that keep it in step with CreateVideo, UpdateVideo and DeleteVideo. FTS5 is
+// only compiled in with the sqlite_fts5 build tag; without it there's no
+// index and SearchVideos falls back to LIKE.
+//
+// VACUUM may renumber the rowids of videos, so the index must be rebuilt
+// after one with INSERT INTO videos_fts (videos_fts) VALUES ('rebuild').
+func migrateVideoSearch(t *tx) error {
+	if t.dialect == dialectPostgres {
+		return execAll(t, []string{
+			`ALTER TABLE videos ADD COLUMN search tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('english', coalesce(description, '')), 'B')
+			) STORED`,
+			`CREATE INDEX videos_search ON videos USING GIN (search)`,
+		})
+	}
+
+	var fts5 bool
+	if err := t.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		return nil
+	}
+	return execAll(t, []string{
+		`CREATE VIRTUAL TABLE videos_fts USING fts5(title, description,
+			content='videos', content_rowid='rowid', prefix='2 3')`,
+		`INSERT INTO videos_fts (videos_fts) VALUES ('rebuild')`,
+		`CREATE TRIGGER videos_fts_insert AFTER INSERT ON videos BEGIN
+			INSERT INTO videos_fts (rowid, title, description)
+			VALUES (new.rowid, new.title, new.description);
+		END`,
+		`CREATE TRIGGER videos_fts_update AFTER UPDATE OF title, description ON videos BEGIN
+			INSERT INTO videos_fts (videos_fts, rowid, title, description)
+			VALUES ('delete', old.rowid, old.title, old.description);
+			INSERT INTO videos_fts (rowid, title, description)
+			VALUES (new.rowid, new.title, new.description);
+		END`,
+		`CREATE TRIGGER videos_fts_delete AFTER DELETE ON videos BEGIN
+			INSERT INTO videos_fts (videos_fts, rowid, title, description)
+			VALUES ('delete', old.rowid, old.title, old.description);
+		END`,
+	})
+}
+
+func revertVideoSearch(t *tx) error {
+	if t.dialect == dialectPostgres {
+		return execAll(t, []string{
+			`DROP INDEX IF EXISTS videos_search`,
+			`ALTER TABLE videos DROP COLUMN IF EXISTS search`,
+		})
+	}
+	return execAll(t, []string{
+		`DROP TRIGGER IF EXISTS videos_fts_insert`,
+		`DROP TRIGGER IF EXISTS videos_fts_update`,
+		`DROP TRIGGER IF EXISTS videos_fts_delete`,
+		`DROP TABLE IF EXISTS videos_fts`,
+	})
+}
+
+// Placeholders the backends wrap matches in, swapped for <mark> tags once the
+// rest of the text is escaped
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+type SearchVideosParams struct {
+	UserID uuid.UUID
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a matching video, best matches first. The highlights are
+// HTML-escaped with matches wrapped in <mark> tags.
+type SearchResult struct {
+	Video            Video   `json:"video"`
+	Rank             float64 `json:"rank"`
+	TitleHighlight   string  `json:"title_highlight"`
+	SnippetHighlight string  `json:"snippet_highlight"`
+}
+
+// searchTerms splits a query into words, dropping the punctuation that has
+// meaning in the backends' query syntax
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// SearchVideos finds a user's videos whose title or description contain
+// every word of the query, each word also matching as a prefix
+func (c Client) SearchVideos(params SearchVideosParams) ([]SearchResult, error) {
+	return c.SearchVideosContext(context.Background(), params)
+}
+
+func (c Client) SearchVideosContext(ctx context.Context, params SearchVideosParams) ([]SearchResult, error) {
+	terms := searchTerms(params.Query)
+	if len(terms) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	var query string
+	var args []any
+	fts := c.db.dialect == dialectPostgres
+	if !fts {
+		err := c.db.QueryRowContext(ctx, "SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'videos_fts'").Scan(&fts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case c.db.dialect == dialectPostgres:
+		matches := make([]string, len(terms))
+		for i, term := range terms {
+			matches[i] = term + ":*"
+		}
+		query = `
+		SELECT ` + prefixColumns("v", videoColumns) + `,
+			ts_rank(v.search, q) AS rank,
+			ts_headline('english', v.title, q, 'StartSel=` + highlightStart + `, StopSel=` + highlightEnd + `, HighlightAll=true'),
+			ts_headline('english', coalesce(v.description, ''), q, 'StartSel=` + highlightStart + `, StopSel=` + highlightEnd + `, MaxWords=24, MinWords=8')
+		FROM videos v, to_tsquery('english', ?) q
+		WHERE v.search @@ q AND v.user_id = ? AND v.deleted_at IS NULL
+		ORDER BY rank DESC, v.created_at DESC
+		LIMIT ? OFFSET ?
+		`
+		args = []any{strings.Join(matches, " & ")}
+	case fts:
+		matches := make([]string, len(terms))
+		for i, term := range terms {
+			matches[i] = `"` + term + `"*`
+		}
+		// bm25 scores better matches lower; titles weigh more than descriptions
+		query = `
+		SELECT ` + prefixColumns("v", videoColumns) + `,
+			-bm25(videos_fts, 10.0, 1.0) AS rank,
+			highlight(videos_fts, 0, '` + highlightStart + `', '` + highlightEnd + `'),
+			snippet(videos_fts, 1, '` + highlightStart + `', '` + highlightEnd + `', '…', 24)
+		FROM videos_fts
+		JOIN videos v ON v.rowid = videos_fts.rowid
+		WHERE videos_fts MATCH ? AND v.user_id = ? AND v.deleted_at IS NULL
+		ORDER BY rank DESC, v.created_at DESC
+		LIMIT ? OFFSET ?
+		`
+		args = []any{strings.Join(matches, " ")}
+	default:
+		// Without FTS5 every word must appear somewhere in the title or
+		// description, and videos rank by how many words their title has.
+		// searchTerms leaves no LIKE wildcards in the words.
+		ranks := make([]string, len(terms))
+		conditions := make([]string, len(terms))
+		for i, term := range terms {
+			ranks[i] = "(CASE WHEN v.title LIKE ? THEN 1 ELSE 0 END)"
+			conditions[i] = "(v.title LIKE ? OR v.description LIKE ?)"
+			args = append(args, "%"+term+"%")
+		}
+		for _, term := range terms {
+			args = append(args, "%"+term+"%", "%"+term+"%")
+		}
+		query = `
+		SELECT ` + prefixColumns("v", videoColumns) + `,
+			` + strings.Join(ranks, " + ") + ` AS rank,
+			v.title,
+			coalesce(v.description, '')
+		FROM videos v
+		WHERE ` + strings.Join(conditions, " AND ") + ` AND v.user_id = ? AND v.deleted_at IS NULL
+		ORDER BY rank DESC, v.created_at DESC
+		LIMIT ? OFFSET ?
+		`
+	}
+	args = append(args, params.UserID, params.Limit, params.Offset)
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		var title, snippet string
+		video, err := scanVideo(scannerWithExtra{rows, []any{&result.Rank, &title, &snippet}})
+		if err != nil {
+			return nil, err
+		}
+		result.Video = video
+		if !fts {
+			title = highlightTerms(title, terms)
+			snippet = highlightTerms(snippetAround(snippet, terms, 24), terms)
+		}
+		result.TitleHighlight = markHighlights(title)
+		result.SnippetHighlight = markHighlights(snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range results {
+		if err := c.loadVideoDetails(ctx, &results[i].Video); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// scannerWithExtra lets scanVideo read a row that has more columns after the
+// video's
+type scannerWithExtra struct {
+	row   interface{ Scan(...any) error }
+	extra []any
+}
+
+func (s scannerWithExtra) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// prefixColumns qualifies a comma separated column list with a table alias
+func prefixColumns(alias, columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, part := range parts {
+		parts[i] = alias + "." + strings.TrimSpace(part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func markHighlights(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, highlightStart, "<mark>")
+	return strings.ReplaceAll(text, highlightEnd, "</mark>")
+}
+
+// termsPattern matches any of the search words, case-insensitively and
+// longest first
+func termsPattern(terms []string) *regexp.Regexp {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	slices.SortFunc(quoted, func(a, b string) int { return len(b) - len(a) })
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// highlightTerms wraps the search words in text in highlight placeholders,
+// for backends that can't highlight matches themselves
+func highlightTerms(text string, terms []string) string {
+	return termsPattern(terms).ReplaceAllString(text, highlightStart+"$0"+highlightEnd)
+}
+
+// snippetAround cuts text down to at most words words, starting a little
+// before the first search word it contains
+func snippetAround(text string, terms []string, words int) string {
+	fields := strings.Fields(text)
+	if len(fields) <= words {
+		return text
+	}
+	pattern := termsPattern(terms)
+	first := slices.IndexFunc(fields, pattern.MatchString)
+	start := max(min(first-words/3, len(fields)-words), 0)
+	snippet := strings.Join(fields[start:start+words], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if start+words < len(fields) {
+		snippet += "…"
+	}
+	return snippet
+}
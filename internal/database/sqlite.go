@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// sqliteSchema is the schema every SQLite database had when migrations were
+// introduced
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		password TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL,
+		tier TEXT NOT NULL DEFAULT 'free'
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS videos (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		thumbnail_url TEXT,
+		thumbnail_srcset TEXT,
+		video_url TEXT TEXT,
+		vertical_video_url TEXT,
+		preview_url TEXT,
+		preview_mp4_url TEXT,
+		duration REAL,
+		audio_url TEXT,
+		audio_size INTEGER,
+		audio_duration REAL,
+		audio_mp3_url TEXT,
+		original_key TEXT,
+		vertical_mode TEXT,
+		pipeline_version INTEGER,
+		current_version_id TEXT,
+		user_id INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS video_chapters (
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		start_seconds REAL NOT NULL,
+		title TEXT NOT NULL,
+		PRIMARY KEY(video_id, position),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS video_versions (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		object_key TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		metadata TEXT NOT NULL,
+		uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		uploader_id TEXT NOT NULL,
+		UNIQUE(video_id, version),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(uploader_id) REFERENCES users(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS video_fingerprints (
+		video_id TEXT PRIMARY KEY,
+		hashes TEXT NOT NULL,
+		duration REAL NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS stored_objects (
+		location TEXT NOT NULL,
+		key TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		category TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(location, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS stored_objects_user_id ON stored_objects(user_id)`,
+}
+
+// sqliteLegacyColumns were added after their tables were first created.
+// Databases from before then get them when they're adopted.
+var sqliteLegacyColumns = []struct{ table, name, definition string }{
+	{"videos", "vertical_video_url", "TEXT"},
+	{"videos", "preview_url", "TEXT"},
+	{"videos", "preview_mp4_url", "TEXT"},
+	{"videos", "duration", "REAL"},
+	{"videos", "audio_url", "TEXT"},
+	{"videos", "audio_size", "INTEGER"},
+	{"videos", "audio_duration", "REAL"},
+	{"videos", "audio_mp3_url", "TEXT"},
+	{"videos", "thumbnail_srcset", "TEXT"},
+	{"videos", "original_key", "TEXT"},
+	{"videos", "vertical_mode", "TEXT"},
+	{"videos", "pipeline_version", "INTEGER"},
+	{"videos", "current_version_id", "TEXT"},
+	{"users", "tier", "TEXT NOT NULL DEFAULT 'free'"},
+}
+
+// addColumnIfMissing adds a column to a SQLite table unless it's already there
+func (t *tx) addColumnIfMissing(table, column, definition string) error {
+	var count int
+	err := t.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+		table, column,
+	).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = t.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// checkForeignKeys fails when any row refers to one that doesn't exist
+func (t *tx) checkForeignKeys() error {
+	rows, err := t.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return err
+		}
+		return fmt.Errorf("row %d of %s refers to a missing row of %s", rowID.Int64, table, parent)
+	}
+	return rows.Err()
+}
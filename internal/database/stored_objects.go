@@ -0,0 +1,190 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StoredObject is a file kept in S3 or the assets directory on behalf of a user
+type StoredObject struct {
+	// Where the file lives, e.g. "s3://bucket" or "assets"
+	Location  string
+	Key       string
+	UserID    uuid.UUID
+	VideoID   uuid.UUID
+	Category  string
+	Size      int64
+	CreatedAt time.Time
+}
+
+type CategoryUsage struct {
+	Category string `json:"category"`
+	Bytes    int64  `json:"bytes"`
+	Objects  int    `json:"objects"`
+}
+
+type StorageConsumer struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Email   string    `json:"email"`
+	Bytes   int64     `json:"bytes"`
+	Objects int       `json:"objects"`
+}
+
+// RecordStoredObject adds an object to its owner's usage, replacing any
+// earlier record of the same object
+func (c Client) RecordStoredObject(object StoredObject) error {
+	return c.RecordStoredObjectContext(context.Background(), object)
+}
+
+func (c Client) RecordStoredObjectContext(ctx context.Context, object StoredObject) error {
+	query := `
+	INSERT INTO stored_objects (location, key, user_id, video_id, category, size, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(location, key) DO UPDATE SET
+		user_id = excluded.user_id,
+		video_id = excluded.video_id,
+		category = excluded.category,
+		size = excluded.size
+	`
+	_, err := c.db.ExecContext(ctx, query, object.Location, object.Key, object.UserID, object.VideoID, object.Category, object.Size)
+	return err
+}
+
+func (c Client) DeleteStoredObject(location, key string) error {
+	return c.DeleteStoredObjectContext(context.Background(), location, key)
+}
+
+func (c Client) DeleteStoredObjectContext(ctx context.Context, location, key string) error {
+	query := `
+	DELETE FROM stored_objects
+	WHERE location = ? AND key = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, location, key)
+	return err
+}
+
+// GetUserStoredObjects returns every object stored on behalf of a user
+func (c Client) GetUserStoredObjects(userID uuid.UUID) ([]StoredObject, error) {
+	return c.GetUserStoredObjectsContext(context.Background(), userID)
+}
+
+func (c Client) GetUserStoredObjectsContext(ctx context.Context, userID uuid.UUID) ([]StoredObject, error) {
+	query := `
+	SELECT location, key, user_id, video_id, category, size, created_at
+	FROM stored_objects
+	WHERE user_id = ?
+	`
+	return c.queryStoredObjects(ctx, query, userID)
+}
+
+// GetVideoStoredObjects returns every object stored for one video
+func (c Client) GetVideoStoredObjects(videoID uuid.UUID) ([]StoredObject, error) {
+	return c.GetVideoStoredObjectsContext(context.Background(), videoID)
+}
+
+func (c Client) GetVideoStoredObjectsContext(ctx context.Context, videoID uuid.UUID) ([]StoredObject, error) {
+	query := `
+	SELECT location, key, user_id, video_id, category, size, created_at
+	FROM stored_objects
+	WHERE video_id = ?
+	`
+	return c.queryStoredObjects(ctx, query, videoID)
+}
+
+func (c Client) queryStoredObjects(ctx context.Context, query string, args ...any) ([]StoredObject, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []StoredObject{}
+	for rows.Next() {
+		var object StoredObject
+		err := rows.Scan(&object.Location, &object.Key, &object.UserID, &object.VideoID, &object.Category, &object.Size, &object.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+// GetUserUsage returns a user's storage use per category
+func (c Client) GetUserUsage(userID uuid.UUID) ([]CategoryUsage, error) {
+	return c.GetUserUsageContext(context.Background(), userID)
+}
+
+func (c Client) GetUserUsageContext(ctx context.Context, userID uuid.UUID) ([]CategoryUsage, error) {
+	query := `
+	SELECT category, SUM(size), COUNT(*)
+	FROM stored_objects
+	WHERE user_id = ?
+	GROUP BY category
+	ORDER BY category
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []CategoryUsage{}
+	for rows.Next() {
+		var category CategoryUsage
+		if err := rows.Scan(&category.Category, &category.Bytes, &category.Objects); err != nil {
+			return nil, err
+		}
+		usage = append(usage, category)
+	}
+	return usage, rows.Err()
+}
+
+// GetUserStorageTotal returns the bytes stored for a user across all categories
+func (c Client) GetUserStorageTotal(userID uuid.UUID) (int64, error) {
+	return c.GetUserStorageTotalContext(context.Background(), userID)
+}
+
+func (c Client) GetUserStorageTotalContext(ctx context.Context, userID uuid.UUID) (int64, error) {
+	query := `
+	SELECT COALESCE(SUM(size), 0)
+	FROM stored_objects
+	WHERE user_id = ?
+	`
+	var total int64
+	err := c.db.QueryRowContext(ctx, query, userID).Scan(&total)
+	return total, err
+}
+
+// GetTopStorageConsumers returns the users storing the most bytes, largest first
+func (c Client) GetTopStorageConsumers(limit int) ([]StorageConsumer, error) {
+	return c.GetTopStorageConsumersContext(context.Background(), limit)
+}
+
+func (c Client) GetTopStorageConsumersContext(ctx context.Context, limit int) ([]StorageConsumer, error) {
+	query := `
+	SELECT o.user_id, COALESCE(u.email, ''), SUM(o.size) AS total, COUNT(*)
+	FROM stored_objects o
+	LEFT JOIN users u ON u.id = o.user_id
+	GROUP BY o.user_id, u.email
+	ORDER BY total DESC
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consumers := []StorageConsumer{}
+	for rows.Next() {
+		var consumer StorageConsumer
+		if err := rows.Scan(&consumer.UserID, &consumer.Email, &consumer.Bytes, &consumer.Objects); err != nil {
+			return nil, err
+		}
+		consumers = append(consumers, consumer)
+	}
+	return consumers, rows.Err()
+}
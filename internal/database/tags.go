@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// migrateVideoTags adds a category column to videos and a tags table shared
+// by every user, linked to videos through video_tags
+func migrateVideoTags(t *tx) error {
+	idType := "TEXT"
+	if t.dialect == dialectPostgres {
+		idType = "UUID"
+	}
+	return execAll(t, []string{
+		`ALTER TABLE videos ADD COLUMN category TEXT`,
+		`CREATE INDEX videos_user_id_category ON videos(user_id, category)`,
+		`CREATE TABLE tags (
+			id ` + idType + ` PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL
+		)`,
+		`CREATE TABLE video_tags (
+			video_id ` + idType + ` NOT NULL REFERENCES videos(id),
+			tag_id ` + idType + ` NOT NULL REFERENCES tags(id),
+			PRIMARY KEY (video_id, tag_id)
+		)`,
+		`CREATE INDEX video_tags_tag_id ON video_tags(tag_id)`,
+	})
+}
+
+func revertVideoTags(t *tx) error {
+	return execAll(t, []string{
+		`DROP TABLE IF EXISTS video_tags`,
+		`DROP TABLE IF EXISTS tags`,
+		`DROP INDEX IF EXISTS videos_user_id_category`,
+		`ALTER TABLE videos DROP COLUMN category`,
+	})
+}
+
+// NormalizeTag lowercases a tag or category and collapses its whitespace, so
+// "Go  Tips" and "go tips" are the same tag
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// normalizeTags normalizes tags and drops empty and repeated ones, keeping
+// the order they were given in
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (c Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	return c.GetVideoTagsContext(context.Background(), videoID)
+}
+
+func (c Client) GetVideoTagsContext(ctx context.Context, videoID uuid.UUID) ([]string, error) {
+	query := `
+	SELECT tags.name
+	FROM video_tags
+	JOIN tags ON tags.id = video_tags.tag_id
+	WHERE video_tags.video_id = ?
+	ORDER BY tags.name
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// SetVideoTags replaces all the tags of a video
+func (c Client) SetVideoTags(videoID uuid.UUID, tags []string) error {
+	return c.SetVideoTagsContext(context.Background(), videoID, tags)
+}
+
+func (c Client) SetVideoTagsContext(ctx context.Context, videoID uuid.UUID, tags []string) error {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	if err := setVideoTags(t, videoID, tags); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+func setVideoTags(t *tx, videoID uuid.UUID, tags []string) error {
+	_, err := t.Exec("DELETE FROM video_tags WHERE video_id = ?", videoID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range normalizeTags(tags) {
+		_, err = t.Exec("INSERT INTO tags (id, name) VALUES (?, ?) ON CONFLICT (name) DO NOTHING", uuid.New(), tag)
+		if err != nil {
+			return err
+		}
+		var tagID uuid.UUID
+		err = t.QueryRow("SELECT id FROM tags WHERE name = ?", tag).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+		_, err = t.Exec("INSERT INTO video_tags (video_id, tag_id) VALUES (?, ?)", videoID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserTags returns the tags on a user's videos outside the trash with how
+// many videos carry each, most used first
+func (c Client) GetUserTags(userID uuid.UUID) ([]TagCount, error) {
+	return c.GetUserTagsContext(context.Background(), userID)
+}
+
+func (c Client) GetUserTagsContext(ctx context.Context, userID uuid.UUID) ([]TagCount, error) {
+	query := `
+	SELECT tags.name, COUNT(*)
+	FROM video_tags
+	JOIN tags ON tags.id = video_tags.tag_id
+	JOIN videos ON videos.id = video_tags.video_id
+	WHERE videos.user_id = ? AND videos.deleted_at IS NULL
+	GROUP BY tags.name
+	ORDER BY COUNT(*) DESC, tags.name
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// migrateSoftDelete lets videos sit in a trash, marked by deleted_at, until
+// they're restored or purged
+func migrateSoftDelete(t *tx) error {
+	timeType := "TIMESTAMP"
+	if t.dialect == dialectPostgres {
+		timeType = "TIMESTAMPTZ"
+	}
+	return execAll(t, []string{
+		`ALTER TABLE videos ADD COLUMN deleted_at ` + timeType,
+		`CREATE INDEX videos_deleted_at ON videos(deleted_at)`,
+	})
+}
+
+func revertSoftDelete(t *tx) error {
+	return execAll(t, []string{
+		`DROP INDEX IF EXISTS videos_deleted_at`,
+		`ALTER TABLE videos DROP COLUMN deleted_at`,
+	})
+}
+
+// TrashVideo moves a video to the trash, hiding it from listings, search and
+// playlists. Its files are kept until it's purged. A video that's already in
+// the trash is ErrNotFound.
+func (c Client) TrashVideo(id uuid.UUID) error {
+	return c.TrashVideoContext(context.Background(), id)
+}
+
+func (c Client) TrashVideoContext(ctx context.Context, id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET deleted_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	return requireRow(c.db.ExecContext(ctx, query, id))
+}
+
+// RestoreVideo takes a video back out of the trash
+func (c Client) RestoreVideo(id uuid.UUID) error {
+	return c.RestoreVideoContext(context.Background(), id)
+}
+
+func (c Client) RestoreVideoContext(ctx context.Context, id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET deleted_at = NULL
+	WHERE id = ?
+	`
+	return requireRow(c.db.ExecContext(ctx, query, id))
+}
+
+// GetTrashedVideos returns a user's videos in the trash, most recently
+// deleted first
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	return c.GetTrashedVideosContext(context.Background(), userID)
+}
+
+func (c Client) GetTrashedVideosContext(ctx context.Context, userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id
+	`
+	return c.queryVideos(ctx, query, userID)
+}
+
+// GetVideosTrashedBefore returns every video that went into the trash
+// before cutoff
+func (c Client) GetVideosTrashedBefore(cutoff time.Time) ([]Video, error) {
+	return c.GetVideosTrashedBeforeContext(context.Background(), cutoff)
+}
+
+func (c Client) GetVideosTrashedBeforeContext(ctx context.Context, cutoff time.Time) ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE deleted_at < ?
+	ORDER BY deleted_at
+	`
+	return c.queryVideos(ctx, query, c.db.dialect.timeArg(cutoff))
+}
@@ -1,8 +1,10 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,6 +14,7 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Tier      string    `json:"tier"`
 	CreateUserParams
 }
 
@@ -21,6 +24,10 @@ type CreateUserParams struct {
 }
 
 func (c Client) GetUsers() ([]User, error) {
+	return c.GetUsersContext(context.Background())
+}
+
+func (c Client) GetUsersContext(ctx context.Context) ([]User, error) {
 	query := `
 		SELECT
 			id,
@@ -28,7 +35,7 @@ func (c Client) GetUsers() ([]User, error) {
 		FROM users
 	`
 
-	rows, err := c.db.Query(query)
+	rows, err := c.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -52,17 +59,21 @@ func (c Client) GetUsers() ([]User, error) {
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
+	return c.GetUserByEmailContext(context.Background(), email)
+}
+
+func (c Client) GetUserByEmailContext(ctx context.Context, email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, tier, email, password
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRowContext(ctx, query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Tier, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, nil
+			return User{}, ErrNotFound
 		}
 		return User{}, err
 	}
@@ -74,8 +85,12 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 }
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
+	return c.GetUserByRefreshTokenContext(context.Background(), token)
+}
+
+func (c Client) GetUserByRefreshTokenContext(ctx context.Context, token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.tier, u.password
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,10 +98,10 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRowContext(ctx, query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Tier, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
@@ -99,6 +114,10 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 }
 
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
+	return c.CreateUserContext(context.Background(), params)
+}
+
+func (c Client) CreateUserContext(ctx context.Context, params CreateUserParams) (*User, error) {
 	id := uuid.New()
 
 	query := `
@@ -107,26 +126,33 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 		VALUES
 		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	_, err := c.db.ExecContext(ctx, query, id.String(), params.Email, params.Password)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("email %s is taken: %w", params.Email, ErrConflict)
+		}
 		return nil, err
 	}
 
-	return c.GetUser(id)
+	return c.GetUserContext(ctx, id)
 }
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
+	return c.GetUserContext(context.Background(), id)
+}
+
+func (c Client) GetUserContext(ctx context.Context, id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, tier, email, password
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRowContext(ctx, query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Tier, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return nil, notFound("user", id)
 		}
 		return nil, err
 	}
@@ -137,11 +163,74 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+// SetUserTier moves a user onto another upload policy tier
+func (c Client) SetUserTier(id uuid.UUID, tier string) error {
+	return c.SetUserTierContext(context.Background(), id, tier)
+}
+
+func (c Client) SetUserTierContext(ctx context.Context, id uuid.UUID, tier string) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET tier = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
-	return err
+	return requireRow(c.db.ExecContext(ctx, query, tier, id.String()))
+}
+
+// UserDeletion counts the rows removed along with a user
+type UserDeletion struct {
+	RefreshTokens int64 `json:"refresh_tokens"`
+	Videos        int64 `json:"videos"`
+	VideoVersions int64 `json:"video_versions"`
+	StoredObjects int64 `json:"stored_objects"`
+	Playlists     int64 `json:"playlists"`
+}
+
+// DeleteUser removes a user along with their refresh tokens, videos,
+// playlists and storage records in one transaction. Files in storage are
+// left to the caller.
+func (c Client) DeleteUser(id uuid.UUID) (UserDeletion, error) {
+	return c.DeleteUserContext(context.Background(), id)
+}
+
+func (c Client) DeleteUserContext(ctx context.Context, id uuid.UUID) (UserDeletion, error) {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return UserDeletion{}, err
+	}
+	defer t.Rollback()
+
+	deletion := UserDeletion{}
+	var users int64
+	steps := []struct {
+		query string
+		count *int64
+	}{
+		{"DELETE FROM refresh_tokens WHERE user_id = ?", &deletion.RefreshTokens},
+		{"DELETE FROM video_chapters WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)", nil},
+		{"DELETE FROM video_fingerprints WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)", nil},
+		{"DELETE FROM video_tags WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)", nil},
+		{"DELETE FROM playlist_items WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)", nil},
+		{"DELETE FROM playlist_items WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)", nil},
+		{"DELETE FROM playlists WHERE user_id = ?", &deletion.Playlists},
+		{"DELETE FROM video_versions WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)", &deletion.VideoVersions},
+		{"DELETE FROM videos WHERE user_id = ?", &deletion.Videos},
+		{"DELETE FROM stored_objects WHERE user_id = ?", &deletion.StoredObjects},
+		{"DELETE FROM users WHERE id = ?", &users},
+	}
+	for _, step := range steps {
+		result, err := t.Exec(step.query, id)
+		if err != nil {
+			return UserDeletion{}, err
+		}
+		if step.count != nil {
+			if *step.count, err = result.RowsAffected(); err != nil {
+				return UserDeletion{}, err
+			}
+		}
+	}
+	if users == 0 {
+		return UserDeletion{}, notFound("user", id)
+	}
+	return deletion, t.Commit()
 }
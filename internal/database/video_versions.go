@@ -0,0 +1,306 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoFiles is the part of a Video produced by processing an uploaded file,
+// which each version keeps a copy of
+type VideoFiles struct {
+	VideoURL         *string  `json:"video_url"`
+	VerticalVideoURL *string  `json:"vertical_video_url"`
+	PreviewURL       *string  `json:"preview_url"`
+	PreviewMP4URL    *string  `json:"preview_mp4_url"`
+	Duration         *float64 `json:"duration"`
+	AudioURL         *string  `json:"audio_url"`
+	AudioSize        *int64   `json:"audio_size"`
+	AudioDuration    *float64 `json:"audio_duration"`
+	AudioMP3URL      *string  `json:"audio_mp3_url"`
+	OriginalKey      *string  `json:"original_key"`
+	VerticalMode     *string  `json:"vertical_mode"`
+	PipelineVersion  *int     `json:"pipeline_version"`
+}
+
+func (f VideoFiles) Value() (driver.Value, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (f *VideoFiles) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), f)
+	case []byte:
+		return json.Unmarshal(v, f)
+	default:
+		return fmt.Errorf("unsupported video files type %T", src)
+	}
+}
+
+func (v Video) Files() VideoFiles {
+	return VideoFiles{
+		VideoURL:         v.VideoURL,
+		VerticalVideoURL: v.VerticalVideoURL,
+		PreviewURL:       v.PreviewURL,
+		PreviewMP4URL:    v.PreviewMP4URL,
+		Duration:         v.Duration,
+		AudioURL:         v.AudioURL,
+		AudioSize:        v.AudioSize,
+		AudioDuration:    v.AudioDuration,
+		AudioMP3URL:      v.AudioMP3URL,
+		OriginalKey:      v.OriginalKey,
+		VerticalMode:     v.VerticalMode,
+		PipelineVersion:  v.PipelineVersion,
+	}
+}
+
+func (v *Video) SetFiles(f VideoFiles) {
+	v.VideoURL = f.VideoURL
+	v.VerticalVideoURL = f.VerticalVideoURL
+	v.PreviewURL = f.PreviewURL
+	v.PreviewMP4URL = f.PreviewMP4URL
+	v.Duration = f.Duration
+	v.AudioURL = f.AudioURL
+	v.AudioSize = f.AudioSize
+	v.AudioDuration = f.AudioDuration
+	v.AudioMP3URL = f.AudioMP3URL
+	v.OriginalKey = f.OriginalKey
+	v.VerticalMode = f.VerticalMode
+	v.PipelineVersion = f.PipelineVersion
+}
+
+type VideoVersion struct {
+	ID         uuid.UUID `json:"id"`
+	Version    int       `json:"version"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	CreateVideoVersionParams
+}
+
+type CreateVideoVersionParams struct {
+	VideoID    uuid.UUID  `json:"video_id"`
+	ObjectKey  string     `json:"object_key"`
+	Checksum   string     `json:"checksum"` // SHA-256 of the uploaded file
+	Size       int64      `json:"size"`
+	Files      VideoFiles `json:"metadata"`
+	UploaderID uuid.UUID  `json:"uploader_id"`
+}
+
+const videoVersionColumns = `
+	id,
+	video_id,
+	version,
+	object_key,
+	checksum,
+	size,
+	metadata,
+	uploaded_at,
+	uploader_id
+`
+
+func scanVideoVersion(row interface{ Scan(...any) error }) (VideoVersion, error) {
+	var version VideoVersion
+	err := row.Scan(
+		&version.ID,
+		&version.VideoID,
+		&version.Version,
+		&version.ObjectKey,
+		&version.Checksum,
+		&version.Size,
+		&version.Files,
+		&version.UploadedAt,
+		&version.UploaderID,
+	)
+	return version, err
+}
+
+// CreateVideoVersion records a new version, numbered after the video's latest one
+func (c Client) CreateVideoVersion(params CreateVideoVersionParams) (VideoVersion, error) {
+	return c.CreateVideoVersionContext(context.Background(), params)
+}
+
+func (c Client) CreateVideoVersionContext(ctx context.Context, params CreateVideoVersionParams) (VideoVersion, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO video_versions (
+		id,
+		video_id,
+		version,
+		object_key,
+		checksum,
+		size,
+		metadata,
+		uploaded_at,
+		uploader_id
+	) VALUES (
+		?,
+		?,
+		(SELECT COALESCE(MAX(version), 0) + 1 FROM video_versions WHERE video_id = ?),
+		?, ?, ?, ?, CURRENT_TIMESTAMP, ?
+	)
+	`
+	_, err := c.db.ExecContext(
+		ctx,
+		query,
+		id,
+		params.VideoID,
+		params.VideoID,
+		params.ObjectKey,
+		params.Checksum,
+		params.Size,
+		params.Files,
+		params.UploaderID,
+	)
+	if err != nil {
+		return VideoVersion{}, err
+	}
+
+	return c.GetVideoVersionContext(ctx, id)
+}
+
+func (c Client) GetVideoVersion(id uuid.UUID) (VideoVersion, error) {
+	return c.GetVideoVersionContext(context.Background(), id)
+}
+
+func (c Client) GetVideoVersionContext(ctx context.Context, id uuid.UUID) (VideoVersion, error) {
+	query := `
+	SELECT ` + videoVersionColumns + `
+	FROM video_versions
+	WHERE id = ?
+	`
+	version, err := scanVideoVersion(c.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoVersion{}, notFound("video version", id)
+		}
+		return VideoVersion{}, err
+	}
+	return version, nil
+}
+
+// GetVideoVersions returns a video's versions, newest first
+func (c Client) GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error) {
+	return c.GetVideoVersionsContext(context.Background(), videoID)
+}
+
+func (c Client) GetVideoVersionsContext(ctx context.Context, videoID uuid.UUID) ([]VideoVersion, error) {
+	query := `
+	SELECT ` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ?
+	ORDER BY version DESC
+	`
+	rows, err := c.db.QueryContext(ctx, query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		version, err := scanVideoVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// ReplaceVideoFiles stores the files of a reprocessed video on its row and
+// its current version, leaving everything else about the video as it is. A
+// thumbnail is only filled in if the video still has none. It fails with
+// ErrConflict when the video was trashed or moved to another version since
+// it was read.
+func (c Client) ReplaceVideoFiles(video Video, objectKey string) error {
+	return c.ReplaceVideoFilesContext(context.Background(), video, objectKey)
+}
+
+func (c Client) ReplaceVideoFilesContext(ctx context.Context, video Video, objectKey string) error {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	files := video.Files()
+	query := `
+	UPDATE videos
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		thumbnail_srcset = CASE WHEN thumbnail_url IS NULL THEN ? ELSE thumbnail_srcset END,
+		thumbnail_url = COALESCE(thumbnail_url, ?),
+		video_url = ?,
+		vertical_video_url = ?,
+		preview_url = ?,
+		preview_mp4_url = ?,
+		duration = ?,
+		audio_url = ?,
+		audio_size = ?,
+		audio_duration = ?,
+		audio_mp3_url = ?,
+		original_key = ?,
+		vertical_mode = ?,
+		pipeline_version = ?
+	WHERE id = ? AND deleted_at IS NULL AND `
+	args := []any{
+		video.ThumbnailSrcset,
+		video.ThumbnailURL,
+		files.VideoURL,
+		files.VerticalVideoURL,
+		files.PreviewURL,
+		files.PreviewMP4URL,
+		files.Duration,
+		files.AudioURL,
+		files.AudioSize,
+		files.AudioDuration,
+		files.AudioMP3URL,
+		files.OriginalKey,
+		files.VerticalMode,
+		files.PipelineVersion,
+		video.ID,
+	}
+	if video.CurrentVersionID != nil {
+		query += "current_version_id = ?"
+		args = append(args, *video.CurrentVersionID)
+	} else {
+		query += "current_version_id IS NULL"
+	}
+	err = requireRow(t.Exec(query, args...))
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("video %s changed while it was reprocessed: %w", video.ID, ErrConflict)
+	}
+	if err != nil {
+		return err
+	}
+
+	if video.CurrentVersionID != nil {
+		query = `
+		UPDATE video_versions
+		SET object_key = ?, metadata = ?
+		WHERE id = ?
+		`
+		if err := requireRow(t.Exec(query, objectKey, files, *video.CurrentVersionID)); err != nil {
+			return err
+		}
+	}
+	return t.Commit()
+}
+
+func (c Client) DeleteVideoVersion(id uuid.UUID) error {
+	return c.DeleteVideoVersionContext(context.Background(), id)
+}
+
+func (c Client) DeleteVideoVersionContext(ctx context.Context, id uuid.UUID) error {
+	return requireRow(c.db.ExecContext(ctx, "DELETE FROM video_versions WHERE id = ?", id))
+}
@@ -1,8 +1,13 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,33 +18,308 @@ type Video struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	// Responsive thumbnail variants as a srcset string per media type
+	ThumbnailSrcset Srcset  `json:"thumbnail_srcset"`
+	VideoURL        *string `json:"video_url"`
+	// VerticalVideoURL points to a 9:16 rendition generated from a 16:9 upload
+	VerticalVideoURL *string `json:"vertical_video_url"`
+	// Short, silent hover previews stored alongside the thumbnail
+	PreviewURL    *string `json:"preview_url"`
+	PreviewMP4URL *string `json:"preview_mp4_url"`
+	// Duration in seconds, known once a video file has been uploaded
+	Duration *float64  `json:"duration"`
+	Chapters []Chapter `json:"chapters"`
+	// Audio-only renditions for listening without the video
+	AudioURL      *string  `json:"audio_url"`
+	AudioSize     *int64   `json:"audio_size"`
+	AudioDuration *float64 `json:"audio_duration"`
+	AudioMP3URL   *string  `json:"audio_mp3_url"`
+	// Where the untouched upload is kept for reprocessing
+	OriginalKey *string `json:"-"`
+	// The vertical_mode the video was uploaded with, reused when reprocessing
+	VerticalMode *string `json:"-"`
+	// Version of the processing pipeline that produced the derivatives
+	PipelineVersion *int `json:"pipeline_version"`
+	// The version of the video file currently served
+	CurrentVersionID *uuid.UUID `json:"current_version_id"`
+	// When the video was moved to the trash, nil unless it's there
+	DeletedAt *time.Time `json:"deleted_at"`
 	CreateVideoParams
 }
 
+// Srcset maps a media type to a srcset attribute value, e.g.
+// "image/webp": "https://.../a.webp 320w, https://.../b.webp 640w"
+type Srcset map[string]string
+
+func (s Srcset) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (s *Srcset) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case []byte:
+		return json.Unmarshal(v, s)
+	default:
+		return fmt.Errorf("unsupported srcset type %T", src)
+	}
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// Stored normalized, see NormalizeTag
+	Category *string  `json:"category"`
+	Tags     []string `json:"tags"`
+}
+
+// Columns read by scanVideo, in order
+const videoColumns = `
+	id,
+	created_at,
+	updated_at,
+	title,
+	description,
+	thumbnail_url,
+	thumbnail_srcset,
+	video_url,
+	vertical_video_url,
+	preview_url,
+	preview_mp4_url,
+	duration,
+	audio_url,
+	audio_size,
+	audio_duration,
+	audio_mp3_url,
+	original_key,
+	vertical_mode,
+	pipeline_version,
+	current_version_id,
+	user_id,
+	category,
+	deleted_at
+`
+
+func scanVideo(row interface{ Scan(...any) error }) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailSrcset,
+		&video.VideoURL,
+		&video.VerticalVideoURL,
+		&video.PreviewURL,
+		&video.PreviewMP4URL,
+		&video.Duration,
+		&video.AudioURL,
+		&video.AudioSize,
+		&video.AudioDuration,
+		&video.AudioMP3URL,
+		&video.OriginalKey,
+		&video.VerticalMode,
+		&video.PipelineVersion,
+		&video.CurrentVersionID,
+		&video.UserID,
+		&video.Category,
+		&video.DeletedAt,
+	)
+	return video, err
 }
 
+// GetVideos returns all of a user's videos, including those in the trash
 func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	return c.GetVideosContext(context.Background(), userID)
+}
+
+func (c Client) GetVideosContext(ctx context.Context, userID uuid.UUID) ([]Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT ` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
 	`
+	return c.queryVideos(ctx, query, userID)
+}
+
+// Expressions the videos listing can be sorted by
+var videoSortExpressions = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"title":      "title",
+	"duration":   "COALESCE(duration, 0)",
+}
+
+// ErrInvalidCursor is returned when a listing continues after a row it
+// doesn't have, e.g. a video of another user
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ListVideosParams struct {
+	UserID uuid.UUID
+	// One of created_at, updated_at, title or duration
+	SortBy    string
+	Ascending bool
+	Limit     int
+	// The last video of the previous page, nil for the first page
+	After *uuid.UUID
+	// Optional filters
+	HasVideo      *bool
+	HasThumbnail  *bool
+	Orientation   string // landscape, portrait or other
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Videos must carry every one of these tags
+	Tags     []string
+	Category string
+}
+
+// ListVideos returns one page of a user's videos outside the trash and
+// whether more follow.
+// Pages are keyed on the sort value and ID of the last video seen, so they
+// stay stable while videos are added.
+func (c Client) ListVideos(params ListVideosParams) ([]Video, bool, error) {
+	return c.ListVideosContext(context.Background(), params)
+}
+
+func (c Client) ListVideosContext(ctx context.Context, params ListVideosParams) ([]Video, bool, error) {
+	sortExpression, ok := videoSortExpressions[params.SortBy]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown sort %q", params.SortBy)
+	}
+
+	conditions := []string{"user_id = ?", "deleted_at IS NULL"}
+	args := []any{params.UserID}
+	if params.HasVideo != nil {
+		conditions = append(conditions, nullCondition("video_url", *params.HasVideo))
+	}
+	if params.HasThumbnail != nil {
+		conditions = append(conditions, nullCondition("thumbnail_url", *params.HasThumbnail))
+	}
+	if params.Orientation != "" {
+		// The upload step files videos under a prefix named after their orientation
+		conditions = append(conditions, "video_url LIKE ?")
+		args = append(args, "%/"+params.Orientation+"/%")
+	}
+	if params.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedBefore))
+	}
+	for _, tag := range normalizeTags(params.Tags) {
+		conditions = append(conditions, `id IN (
+			SELECT video_tags.video_id
+			FROM video_tags
+			JOIN tags ON tags.id = video_tags.tag_id
+			WHERE tags.name = ?
+		)`)
+		args = append(args, tag)
+	}
+	if params.Category != "" {
+		conditions = append(conditions, "category = ?")
+		args = append(args, NormalizeTag(params.Category))
+	}
+
+	direction, comparison := "DESC", "<"
+	if params.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+	if params.After != nil {
+		var count int
+		err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM videos WHERE id = ? AND user_id = ?", *params.After, params.UserID).Scan(&count)
+		if err != nil {
+			return nil, false, err
+		}
+		if count == 0 {
+			return nil, false, ErrInvalidCursor
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"(%s, id) %s (SELECT %s, id FROM videos WHERE id = ?)",
+			sortExpression, comparison, sortExpression,
+		))
+		args = append(args, *params.After)
+	}
+
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + sortExpression + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	// One extra row tells whether there's another page
+	args = append(args, params.Limit+1)
+	videos, err := c.queryVideos(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(videos) > params.Limit {
+		return videos[:params.Limit], true, nil
+	}
+	return videos, false, nil
+}
+
+func nullCondition(column string, notNull bool) string {
+	if notNull {
+		return column + " IS NOT NULL"
+	}
+	return column + " IS NULL"
+}
+
+// CountUploadedVideos returns how many of a user's videos have a video file
+func (c Client) CountUploadedVideos(userID uuid.UUID) (int, error) {
+	return c.CountUploadedVideosContext(context.Background(), userID)
+}
+
+func (c Client) CountUploadedVideosContext(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE user_id = ?
+	AND video_url IS NOT NULL
+	`
+	var count int
+	err := c.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// GetVideosForReprocessing returns the videos with a stored original that
+// were processed by a pipeline older than version
+func (c Client) GetVideosForReprocessing(version int) ([]Video, error) {
+	return c.GetVideosForReprocessingContext(context.Background(), version)
+}
 
-	rows, err := c.db.Query(query, userID)
+func (c Client) GetVideosForReprocessingContext(ctx context.Context, version int) ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE original_key IS NOT NULL
+	AND deleted_at IS NULL
+	AND (pipeline_version IS NULL OR pipeline_version < ?)
+	ORDER BY created_at
+	`
+	return c.queryVideos(ctx, query, version)
+}
+
+func (c Client) queryVideos(ctx context.Context, query string, args ...any) ([]Video, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,26 +327,48 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range videos {
+		if err := c.loadVideoDetails(ctx, &videos[i]); err != nil {
+			return nil, err
+		}
+	}
 
 	return videos, nil
 }
 
+// loadVideoDetails fills in what's stored outside the videos table
+func (c Client) loadVideoDetails(ctx context.Context, video *Video) error {
+	var err error
+	video.Chapters, err = c.GetChaptersContext(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	video.Tags, err = c.GetVideoTagsContext(ctx, video.ID)
+	return err
+}
+
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
+	return c.CreateVideoContext(context.Background(), params)
+}
+
+func (c Client) CreateVideoContext(ctx context.Context, params CreateVideoParams) (Video, error) {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return Video{}, err
+	}
+	defer t.Rollback()
+
 	id := uuid.New()
 	query := `
 	INSERT INTO videos (
@@ -75,81 +377,144 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		category
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err = t.Exec(query, id, params.Title, params.Description, params.UserID, normalizeCategory(params.Category))
 	if err != nil {
 		return Video{}, err
 	}
+	if err := setVideoTags(t, id, params.Tags); err != nil {
+		return Video{}, err
+	}
+	if err := t.Commit(); err != nil {
+		return Video{}, err
+	}
 
-	return c.GetVideo(id)
+	return c.GetVideoContext(ctx, id)
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	return c.GetVideoContext(context.Background(), id)
+}
+
+func (c Client) GetVideoContext(ctx context.Context, id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT ` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Video{}, nil
+			return Video{}, notFound("video", id)
 		}
 		return Video{}, err
 	}
 
+	if err := c.loadVideoDetails(ctx, &video); err != nil {
+		return Video{}, err
+	}
+
 	return video, nil
 }
 
 func (c Client) UpdateVideo(video Video) error {
+	return c.UpdateVideoContext(context.Background(), video)
+}
+
+func (c Client) UpdateVideoContext(ctx context.Context, video Video) error {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = CURRENT_TIMESTAMP,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_srcset = ?,
 		video_url = ?,
-		user_id = ?
+		vertical_video_url = ?,
+		preview_url = ?,
+		preview_mp4_url = ?,
+		duration = ?,
+		audio_url = ?,
+		audio_size = ?,
+		audio_duration = ?,
+		audio_mp3_url = ?,
+		original_key = ?,
+		vertical_mode = ?,
+		pipeline_version = ?,
+		current_version_id = ?,
+		user_id = ?,
+		category = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	result, err := c.db.ExecContext(
+		ctx,
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		video.ThumbnailSrcset,
 		&video.VideoURL,
+		&video.VerticalVideoURL,
+		&video.PreviewURL,
+		&video.PreviewMP4URL,
+		video.Duration,
+		video.AudioURL,
+		video.AudioSize,
+		video.AudioDuration,
+		video.AudioMP3URL,
+		video.OriginalKey,
+		video.VerticalMode,
+		video.PipelineVersion,
+		video.CurrentVersionID,
 		video.UserID,
+		normalizeCategory(video.Category),
 		video.ID,
 	)
-	return err
+	return requireRow(result, err)
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
-	query := `
-	DELETE FROM videos
-	WHERE id = ?
-	`
-	_, err := c.db.Exec(query, id)
-	return err
+	return c.DeleteVideoContext(context.Background(), id)
+}
+
+func (c Client) DeleteVideoContext(ctx context.Context, id uuid.UUID) error {
+	t, err := c.db.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM video_chapters WHERE video_id = ?",
+		"DELETE FROM video_tags WHERE video_id = ?",
+		"DELETE FROM playlist_items WHERE video_id = ?",
+		"DELETE FROM video_versions WHERE video_id = ?",
+		"DELETE FROM video_fingerprints WHERE video_id = ?",
+	} {
+		if _, err := t.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	if err := requireRow(t.Exec("DELETE FROM videos WHERE id = ?", id)); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// normalizeCategory stores an empty category as none at all
+func normalizeCategory(category *string) *string {
+	if category == nil {
+		return nil
+	}
+	normalized := NormalizeTag(*category)
+	if normalized == "" {
+		return nil
+	}
+	return &normalized
 }
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestClient returns a client for a fresh, fully migrated SQLite database
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	if _, err := c.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestUser(t *testing.T, c Client, email string) uuid.UUID {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: email, Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func TestListVideosCursor(t *testing.T) {
+	c := newTestClient(t)
+	userID := newTestUser(t, c, "user@example.com")
+	otherVideo, err := c.CreateVideo(CreateVideoParams{Title: "other", UserID: newTestUser(t, c, "other@example.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Four of the videos share a creation time and two a title, so pages
+	// have to fall back on the ID to stay in order
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	seeds := []struct {
+		title     string
+		createdAt time.Time
+	}{
+		{"b", day},
+		{"a", day},
+		{"c", day},
+		{"a", day},
+		{"d", day.Add(-time.Hour)},
+		{"e", day.Add(time.Hour)},
+	}
+	type listed struct {
+		id        uuid.UUID
+		title     string
+		createdAt time.Time
+	}
+	videos := []listed{}
+	for _, seed := range seeds {
+		video, err := c.CreateVideo(CreateVideoParams{Title: seed.title, UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.db.ExecContext(context.Background(), "UPDATE videos SET created_at = ? WHERE id = ?", seed.createdAt, video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		videos = append(videos, listed{video.ID, seed.title, seed.createdAt})
+	}
+
+	tests := []struct {
+		name      string
+		sortBy    string
+		ascending bool
+		limit     int
+		compare   func(a, b listed) int
+	}{
+		{
+			name:    "newest first",
+			sortBy:  "created_at",
+			limit:   2,
+			compare: func(a, b listed) int { return a.createdAt.Compare(b.createdAt) },
+		},
+		{
+			name:      "oldest first",
+			sortBy:    "created_at",
+			ascending: true,
+			limit:     2,
+			compare:   func(a, b listed) int { return a.createdAt.Compare(b.createdAt) },
+		},
+		{
+			name:      "one per page",
+			sortBy:    "created_at",
+			ascending: true,
+			limit:     1,
+			compare:   func(a, b listed) int { return a.createdAt.Compare(b.createdAt) },
+		},
+		{
+			name:      "by title",
+			sortBy:    "title",
+			ascending: true,
+			limit:     4,
+			compare:   func(a, b listed) int { return strings.Compare(a.title, b.title) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := slices.Clone(videos)
+			slices.SortFunc(want, func(a, b listed) int {
+				cmp := tt.compare(a, b)
+				if cmp == 0 {
+					cmp = strings.Compare(a.id.String(), b.id.String())
+				}
+				if !tt.ascending {
+					cmp = -cmp
+				}
+				return cmp
+			})
+			wantIDs := []uuid.UUID{}
+			for _, video := range want {
+				wantIDs = append(wantIDs, video.id)
+			}
+
+			gotIDs := []uuid.UUID{}
+			var after *uuid.UUID
+			for page := 0; ; page++ {
+				if page > len(videos) {
+					t.Fatal("listing never ran out of pages")
+				}
+				params := ListVideosParams{UserID: userID, SortBy: tt.sortBy, Ascending: tt.ascending, Limit: tt.limit, After: after}
+				got, more, err := c.ListVideos(params)
+				if err != nil {
+					t.Fatalf("ListVideos: %v", err)
+				}
+				if len(got) > tt.limit {
+					t.Fatalf("got %d videos on a page of %d", len(got), tt.limit)
+				}
+				for _, video := range got {
+					gotIDs = append(gotIDs, video.ID)
+				}
+				if !more {
+					break
+				}
+				after = &got[len(got)-1].ID
+			}
+			if !slices.Equal(gotIDs, wantIDs) {
+				t.Errorf("got videos %v, want %v", gotIDs, wantIDs)
+			}
+		})
+	}
+
+	t.Run("cursor of another user", func(t *testing.T) {
+		params := ListVideosParams{UserID: userID, SortBy: "created_at", Limit: 2, After: &otherVideo.ID}
+		if _, _, err := c.ListVideos(params); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("got error %v, want ErrInvalidCursor", err)
+		}
+	})
+}
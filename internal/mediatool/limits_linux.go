@@ -0,0 +1,14 @@
+//go:build linux
+
+package mediatool
+
+import "strconv"
+
+// withFileSizeLimit runs the tool through sh, which sets RLIMIT_FSIZE before
+// it execs the tool, so the kernel stops it with SIGXFSZ once it tries to
+// write past the limit. sh's ulimit -f counts 512-byte blocks.
+func withFileSizeLimit(name string, args []string, maxBytes int64) (string, []string) {
+	blocks := max(maxBytes/512, 1)
+	shArgs := []string{"-c", `ulimit -f "$1" && shift && exec "$@"`, "mediatool", strconv.FormatInt(blocks, 10), name}
+	return "/bin/sh", append(shArgs, args...)
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package mediatool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecRunnerMaxFileSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    string
+		wantErr bool
+	}{
+		{name: "under the limit", size: "4000"},
+		{name: "at the limit", size: "4096"},
+		{name: "past the limit", size: "1000000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "out file")
+			runner := ExecRunner{MaxFileSize: 4096}
+			// The limit is in place before the first write, with no pause
+			_, err := runner.Run(context.Background(), "sh", "-c", `head -c "$1" /dev/zero > "$2"`, "sh", tt.size, outputPath)
+			if tt.wantErr {
+				var runErr *Error
+				if !errors.As(err, &runErr) || runErr.Tool != "sh" {
+					t.Fatalf("got error %v, want an *Error from sh", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			info, err := os.Stat(outputPath)
+			if err != nil {
+				t.Fatalf("stat output: %v", err)
+			}
+			if info.Size() > 4096 {
+				t.Errorf("output grew to %d bytes, want at most 4096", info.Size())
+			}
+		})
+	}
+}
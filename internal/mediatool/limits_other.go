@@ -0,0 +1,7 @@
+//go:build !linux
+
+package mediatool
+
+func withFileSizeLimit(name string, args []string, maxBytes int64) (string, []string) {
+	return name, args
+}
@@ -0,0 +1,195 @@
+// Package mediatool runs external media tools such as ffmpeg and ffprobe
+// under the caller's context with time and output limits.
+package mediatool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runner runs a media tool and returns what it wrote to stdout
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+var ErrOutputLimit = errors.New("output limit exceeded")
+
+// Error describes a failed run, including what the tool wrote to stderr
+type Error struct {
+	Tool     string
+	Args     []string
+	ExitCode int
+	// Stderr holds the last non-empty lines the tool wrote to stderr
+	Stderr   []string
+	Duration time.Duration
+	Err      error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s failed after %s (exit code %d): %v", e.Tool, e.Duration.Round(time.Millisecond), e.ExitCode, e.Err)
+	if len(e.Stderr) > 0 {
+		msg += ": " + strings.Join(e.Stderr, "; ")
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Number of stderr lines kept in an Error
+const maxStderrLines = 20
+
+// ExecRunner runs tools as child processes. When the context is cancelled or
+// the timeout passes, the tool and every process it started are killed.
+type ExecRunner struct {
+	// Timeout is the wall-clock limit of a single run, zero means no limit
+	Timeout time.Duration
+	// MaxFileSize caps the size of any file the tool writes, zero means no
+	// limit. Only enforced on Linux, rounded down to 512 bytes.
+	MaxFileSize int64
+	// MaxCapturedOutput caps how much stdout and stderr is buffered, a tool
+	// writing more is killed
+	MaxCapturedOutput int64
+}
+
+func (r ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	cmdName, cmdArgs := name, args
+	if r.MaxFileSize > 0 {
+		cmdName, cmdArgs = withFileSizeLimit(name, args, r.MaxFileSize)
+	}
+	cmd := exec.CommandContext(ctx, cmdName, cmdArgs...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	// Don't wait forever on pipes held open by orphaned grandchildren
+	cmd.WaitDelay = 5 * time.Second
+
+	limit := &outputLimit{max: r.MaxCapturedOutput}
+	stdout := &limitedBuffer{limit: limit}
+	stderr := &limitedBuffer{limit: limit}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		limit.onExceeded(func() { killProcessGroup(cmd) })
+		err = cmd.Wait()
+	}
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+
+	runErr := &Error{
+		Tool:     name,
+		Args:     args,
+		ExitCode: -1,
+		Stderr:   lastLines(stderr.String(), maxStderrLines),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		runErr.ExitCode = exitErr.ExitCode()
+	}
+	switch {
+	case limit.exceeded():
+		runErr.Err = ErrOutputLimit
+	case ctx.Err() != nil:
+		runErr.Err = ctx.Err()
+	}
+	return nil, runErr
+}
+
+// outputLimit is shared by the stdout and stderr buffers of a run
+type outputLimit struct {
+	mu      sync.Mutex
+	max     int64
+	written int64
+	hit     bool
+	kill    func()
+}
+
+func (l *outputLimit) add(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.written += int64(n)
+	if l.max > 0 && l.written > l.max && !l.hit {
+		l.hit = true
+		if l.kill != nil {
+			go l.kill()
+		}
+	}
+	return !l.hit
+}
+
+func (l *outputLimit) onExceeded(kill func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.kill = kill
+	if l.hit {
+		go kill()
+	}
+}
+
+func (l *outputLimit) exceeded() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hit
+}
+
+type limitedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit *outputLimit
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if !b.limit.add(len(p)) {
+		// Report success so the copy goroutine keeps draining the pipe
+		// until the process is gone
+		return len(p), nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func lastLines(s string, n int) []string {
+	lines := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
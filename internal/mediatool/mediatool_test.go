@@ -0,0 +1,66 @@
+package mediatool
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecRunnerOutput(t *testing.T) {
+	out, err := ExecRunner{}.Run(context.Background(), "sh", "-c", "echo hello")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("got output %q, want %q", out, "hello\n")
+	}
+}
+
+func TestExecRunnerError(t *testing.T) {
+	_, err := ExecRunner{}.Run(context.Background(), "sh", "-c", "echo first >&2; echo; echo second >&2; exit 3")
+	var runErr *Error
+	if !errors.As(err, &runErr) {
+		t.Fatalf("got error %v, want an *Error", err)
+	}
+	if runErr.Tool != "sh" || runErr.ExitCode != 3 {
+		t.Errorf("got tool %q exit code %d, want sh and 3", runErr.Tool, runErr.ExitCode)
+	}
+	if got := strings.Join(runErr.Stderr, "|"); got != "first|second" {
+		t.Errorf("got stderr %q, want %q", got, "first|second")
+	}
+}
+
+func TestExecRunnerOutputLimit(t *testing.T) {
+	runner := ExecRunner{MaxCapturedOutput: 1000}
+	start := time.Now()
+	_, err := runner.Run(context.Background(), "sh", "-c", "while true; do echo 0123456789; done")
+	if !errors.Is(err, ErrOutputLimit) {
+		t.Fatalf("got error %v, want ErrOutputLimit", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("tool was killed after %s", elapsed)
+	}
+}
+
+func TestExecRunnerTimeout(t *testing.T) {
+	runner := ExecRunner{Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := runner.Run(context.Background(), "sleep", "30")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("tool was killed after %s", elapsed)
+	}
+}
+
+func TestExecRunnerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err := ExecRunner{}.Run(ctx, "sleep", "30")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package mediatool
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the tool in its own process group so that it can be
+// killed together with anything it spawns
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,57 @@
+//go:build unix
+
+package mediatool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// exited reports whether a process is gone, counting zombies nobody has
+// reaped yet as gone
+func exited(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return true
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesised command name
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestExecRunnerTimeoutKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	runner := ExecRunner{Timeout: 200 * time.Millisecond}
+	// The shell starts a grandchild that would outlive a plain kill of the shell
+	_, err := runner.Run(context.Background(), "sh", "-c", "sleep 30 & echo $! > "+pidFile+"; wait")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("reading grandchild pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("parsing grandchild pid: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !exited(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("grandchild %d is still running", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,328 @@
+// Package mp4 reads the metadata of MP4/ISO-BMFF files without decoding them,
+// enough to tell a video's dimensions, rotation and duration and whether it is
+// already laid out for fast start.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrNotMP4 is returned for files that don't look like ISO-BMFF at all
+	ErrNotMP4 = errors.New("not an MP4 file")
+	// ErrUnsupported is returned for MP4 files this package can't make sense
+	// of, callers should fall back to a full probe
+	ErrUnsupported = errors.New("unsupported MP4 layout")
+)
+
+// Largest moov box that is read into memory
+const maxMoovSize = 64 << 20
+
+type Info struct {
+	// Display dimensions of the first video track, rotation already applied
+	Width  int
+	Height int
+	// Clockwise rotation of the first video track in degrees: 0, 90, 180 or 270
+	Rotation int
+	// Duration of the presentation in seconds
+	Duration float64
+	// FastStart is true when the moov box comes before the media data, so
+	// playback can begin before the whole file is downloaded
+	FastStart bool
+}
+
+// Parse reads the top-level boxes of r and the metadata in its moov box
+func Parse(r io.ReadSeeker) (Info, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Info{}, err
+	}
+
+	var moov []byte
+	seenMdat := false
+	fastStart := false
+	first := true
+	for moov == nil {
+		boxType, payloadSize, err := readBoxHeader(r)
+		if err == io.EOF && !first {
+			break
+		}
+		if err != nil {
+			if first {
+				return Info{}, ErrNotMP4
+			}
+			return Info{}, err
+		}
+		if first && boxType != "ftyp" {
+			return Info{}, ErrNotMP4
+		}
+		first = false
+
+		switch boxType {
+		case "moov":
+			if payloadSize < 0 || payloadSize > maxMoovSize {
+				return Info{}, fmt.Errorf("%w: moov box of %d bytes", ErrUnsupported, payloadSize)
+			}
+			moov = make([]byte, payloadSize)
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return Info{}, fmt.Errorf("%w: truncated moov box", ErrUnsupported)
+			}
+			fastStart = !seenMdat
+		case "mdat":
+			seenMdat = true
+			fallthrough
+		default:
+			if payloadSize < 0 {
+				// The box runs to the end of the file
+				break
+			}
+			if _, err := r.Seek(payloadSize, io.SeekCurrent); err != nil {
+				return Info{}, err
+			}
+		}
+		if payloadSize < 0 {
+			break
+		}
+	}
+	if moov == nil {
+		return Info{}, fmt.Errorf("%w: no moov box", ErrUnsupported)
+	}
+
+	info, err := parseMoov(moov)
+	if err != nil {
+		return Info{}, err
+	}
+	info.FastStart = fastStart
+	return info, nil
+}
+
+// readBoxHeader returns the type of the next box and the size of its payload,
+// or -1 when the box extends to the end of the file
+func readBoxHeader(r io.Reader) (string, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", 0, fmt.Errorf("%w: truncated box header", ErrUnsupported)
+		}
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[0:4]))
+	boxType := string(header[4:8])
+
+	switch size {
+	case 0:
+		return boxType, -1, nil
+	case 1:
+		var largeSize [8]byte
+		if _, err := io.ReadFull(r, largeSize[:]); err != nil {
+			return "", 0, fmt.Errorf("%w: truncated box header", ErrUnsupported)
+		}
+		size = int64(binary.BigEndian.Uint64(largeSize[:]))
+		if size < 16 {
+			return "", 0, fmt.Errorf("%w: invalid %s box size", ErrUnsupported, boxType)
+		}
+		return boxType, size - 16, nil
+	default:
+		if size < 8 {
+			return "", 0, fmt.Errorf("%w: invalid %s box size", ErrUnsupported, boxType)
+		}
+		return boxType, size - 8, nil
+	}
+}
+
+type box struct {
+	boxType string
+	payload []byte
+}
+
+// childBoxes splits an in-memory container payload into its child boxes
+func childBoxes(data []byte) ([]box, error) {
+	boxes := []box{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("%w: truncated box", ErrUnsupported)
+		}
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("%w: truncated box", ErrUnsupported)
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: invalid %s box size", ErrUnsupported, boxType)
+		}
+		boxes = append(boxes, box{boxType: boxType, payload: data[headerSize:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []box, boxType string) (box, bool) {
+	for _, b := range boxes {
+		if b.boxType == boxType {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// findPath descends through nested containers, e.g. "mdia", "minf", "stbl"
+func findPath(data []byte, path ...string) ([]byte, error) {
+	for _, boxType := range path {
+		boxes, err := childBoxes(data)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := findBox(boxes, boxType)
+		if !ok {
+			return nil, fmt.Errorf("%w: missing %s box", ErrUnsupported, boxType)
+		}
+		data = b.payload
+	}
+	return data, nil
+}
+
+func parseMoov(moov []byte) (Info, error) {
+	boxes, err := childBoxes(moov)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	mvhd, ok := findBox(boxes, "mvhd")
+	if !ok {
+		return Info{}, fmt.Errorf("%w: missing mvhd box", ErrUnsupported)
+	}
+	info.Duration, err = parseMvhd(mvhd.payload)
+	if err != nil {
+		return Info{}, err
+	}
+
+	for _, trak := range boxes {
+		if trak.boxType != "trak" {
+			continue
+		}
+		hdlr, err := findPath(trak.payload, "mdia", "hdlr")
+		if err != nil {
+			return Info{}, err
+		}
+		if len(hdlr) < 12 {
+			return Info{}, fmt.Errorf("%w: truncated hdlr box", ErrUnsupported)
+		}
+		if string(hdlr[8:12]) != "vide" {
+			continue
+		}
+
+		tkhd, err := findPath(trak.payload, "tkhd")
+		if err != nil {
+			return Info{}, err
+		}
+		width, height, rotation, err := parseTkhd(tkhd)
+		if err != nil {
+			return Info{}, err
+		}
+		// Prefer the coded size from the sample description, tkhd only
+		// carries the presentation size
+		stsd, err := findPath(trak.payload, "mdia", "minf", "stbl", "stsd")
+		if err != nil {
+			return Info{}, err
+		}
+		if codedWidth, codedHeight, ok := parseStsd(stsd); ok {
+			width, height = codedWidth, codedHeight
+		}
+		if width == 0 || height == 0 {
+			return Info{}, fmt.Errorf("%w: video track has no dimensions", ErrUnsupported)
+		}
+
+		if rotation == 90 || rotation == 270 {
+			width, height = height, width
+		}
+		info.Width, info.Height, info.Rotation = width, height, rotation
+		return info, nil
+	}
+
+	return Info{}, fmt.Errorf("%w: no video track", ErrUnsupported)
+}
+
+func parseMvhd(data []byte) (float64, error) {
+	if len(data) < 4 {
+		return 0, fmt.Errorf("%w: truncated mvhd box", ErrUnsupported)
+	}
+	var timescale uint32
+	var duration uint64
+	switch data[0] {
+	case 0:
+		if len(data) < 20 {
+			return 0, fmt.Errorf("%w: truncated mvhd box", ErrUnsupported)
+		}
+		timescale = binary.BigEndian.Uint32(data[12:16])
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	case 1:
+		if len(data) < 32 {
+			return 0, fmt.Errorf("%w: truncated mvhd box", ErrUnsupported)
+		}
+		timescale = binary.BigEndian.Uint32(data[20:24])
+		duration = binary.BigEndian.Uint64(data[24:32])
+	default:
+		return 0, fmt.Errorf("%w: mvhd version %d", ErrUnsupported, data[0])
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("%w: mvhd timescale is zero", ErrUnsupported)
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+func parseTkhd(data []byte) (width, height, rotation int, err error) {
+	if len(data) < 1 {
+		return 0, 0, 0, fmt.Errorf("%w: truncated tkhd box", ErrUnsupported)
+	}
+	// Skip version/flags, times, track ID, reserved and duration
+	offset := 4 + 20
+	if data[0] == 1 {
+		offset = 4 + 32
+	}
+	// Then reserved, layer, alternate group, volume and reserved
+	offset += 16
+	if len(data) < offset+36+8 {
+		return 0, 0, 0, fmt.Errorf("%w: truncated tkhd box", ErrUnsupported)
+	}
+
+	matrix := data[offset : offset+36]
+	a := int32(binary.BigEndian.Uint32(matrix[0:4]))
+	b := int32(binary.BigEndian.Uint32(matrix[4:8]))
+	switch {
+	case a == 0 && b > 0:
+		rotation = 90
+	case a == 0 && b < 0:
+		rotation = 270
+	case a < 0:
+		rotation = 180
+	}
+
+	// Width and height are 16.16 fixed point
+	width = int(binary.BigEndian.Uint32(data[offset+36:offset+40]) >> 16)
+	height = int(binary.BigEndian.Uint32(data[offset+40:offset+44]) >> 16)
+	return width, height, rotation, nil
+}
+
+// parseStsd reads the width and height of the first visual sample entry
+func parseStsd(data []byte) (width, height int, ok bool) {
+	// version/flags, entry count, then the sample entry's size and type,
+	// reserved, data reference index, pre-defined and reserved fields
+	const widthOffset = 8 + 8 + 6 + 2 + 16
+	if len(data) < widthOffset+4 {
+		return 0, 0, false
+	}
+	width = int(binary.BigEndian.Uint16(data[widthOffset : widthOffset+2]))
+	height = int(binary.BigEndian.Uint16(data[widthOffset+2 : widthOffset+4]))
+	return width, height, width > 0 && height > 0
+}
@@ -0,0 +1,204 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// mkBox builds a box with a 32-bit size
+func mkBox(boxType string, parts ...[]byte) []byte {
+	payload := concat(parts...)
+	return concat(u32(uint32(8+len(payload))), []byte(boxType), payload)
+}
+
+// mkBox64 builds a box whose size is in the 64-bit largesize field
+func mkBox64(boxType string, parts ...[]byte) []byte {
+	payload := concat(parts...)
+	return concat(u32(1), []byte(boxType), u64(uint64(16+len(payload))), payload)
+}
+
+var ftyp = mkBox("ftyp", []byte("isom"), u32(512), []byte("isomavc1"))
+
+func mvhd(timescale, duration uint32) []byte {
+	return mkBox("mvhd", u32(0), u32(0), u32(0), u32(timescale), u32(duration), make([]byte, 80))
+}
+
+func mvhd64(timescale uint32, duration uint64) []byte {
+	return mkBox("mvhd", u32(1<<24), u64(0), u64(0), u32(timescale), u64(duration), make([]byte, 80))
+}
+
+// Transformation matrices as stored in tkhd, in 16.16 fixed point
+var (
+	identity  = [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+	rotate90  = [9]int32{0, 0x10000, 0, -0x10000, 0, 0, 0, 0, 0x40000000}
+	rotate180 = [9]int32{-0x10000, 0, 0, 0, -0x10000, 0, 0, 0, 0x40000000}
+	rotate270 = [9]int32{0, -0x10000, 0, 0x10000, 0, 0, 0, 0, 0x40000000}
+)
+
+func tkhdPayload(version byte, matrix [9]int32, width, height int) []byte {
+	times := make([]byte, 20)
+	if version == 1 {
+		times = make([]byte, 32)
+	}
+	m := []byte{}
+	for _, v := range matrix {
+		m = append(m, u32(uint32(v))...)
+	}
+	return concat([]byte{version, 0, 0, 3}, times, make([]byte, 16), m, u32(uint32(width)<<16), u32(uint32(height)<<16))
+}
+
+func tkhd(version byte, matrix [9]int32, width, height int) []byte {
+	return mkBox("tkhd", tkhdPayload(version, matrix, width, height))
+}
+
+func hdlr(handler string) []byte {
+	return mkBox("hdlr", u32(0), u32(0), []byte(handler), make([]byte, 12), []byte("handler\x00"))
+}
+
+// stsd holds one avc1 sample entry with the given coded size
+func stsd(width, height int) []byte {
+	entry := mkBox("avc1", make([]byte, 6), u16(1), make([]byte, 16), u16(uint16(width)), u16(uint16(height)), make([]byte, 50))
+	return mkBox("stsd", u32(0), u32(1), entry)
+}
+
+func trak(tkhdBox []byte, handler string, stsdBox []byte) []byte {
+	return mkBox("trak", tkhdBox, mkBox("mdia", hdlr(handler), mkBox("minf", mkBox("stbl", stsdBox))))
+}
+
+func videoTrak(matrix [9]int32, width, height int) []byte {
+	return trak(tkhd(0, matrix, width, height), "vide", stsd(width, height))
+}
+
+func audioTrak() []byte {
+	return trak(tkhd(0, identity, 0, 0), "soun", mkBox("stsd", u32(0), u32(0)))
+}
+
+var mdat = mkBox("mdat", make([]byte, 64))
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want Info
+	}{
+		{
+			name: "fast start landscape",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 10500), videoTrak(identity, 1920, 1080)), mdat),
+			want: Info{Width: 1920, Height: 1080, Duration: 10.5, FastStart: true},
+		},
+		{
+			name: "moov after mdat",
+			file: concat(ftyp, mdat, mkBox("moov", mvhd(1000, 10500), videoTrak(identity, 1920, 1080))),
+			want: Info{Width: 1920, Height: 1080, Duration: 10.5},
+		},
+		{
+			name: "video track after audio track",
+			file: concat(ftyp, mkBox("moov", mvhd(600, 1200), audioTrak(), videoTrak(identity, 1280, 720)), mdat),
+			want: Info{Width: 1280, Height: 720, Duration: 2, FastStart: true},
+		},
+		{
+			name: "rotated 90 degrees",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 5000), videoTrak(rotate90, 1920, 1080)), mdat),
+			want: Info{Width: 1080, Height: 1920, Rotation: 90, Duration: 5, FastStart: true},
+		},
+		{
+			name: "rotated 180 degrees",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 5000), videoTrak(rotate180, 1920, 1080)), mdat),
+			want: Info{Width: 1920, Height: 1080, Rotation: 180, Duration: 5, FastStart: true},
+		},
+		{
+			name: "rotated 270 degrees",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 5000), videoTrak(rotate270, 1920, 1080)), mdat),
+			want: Info{Width: 1080, Height: 1920, Rotation: 270, Duration: 5, FastStart: true},
+		},
+		{
+			name: "coded size preferred over presentation size",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 5000), trak(tkhd(0, identity, 1916, 1076), "vide", stsd(1920, 1080))), mdat),
+			want: Info{Width: 1920, Height: 1080, Duration: 5, FastStart: true},
+		},
+		{
+			name: "presentation size when the sample entry has none",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 5000), trak(tkhd(0, rotate90, 640, 480), "vide", stsd(0, 0))), mdat),
+			want: Info{Width: 480, Height: 640, Rotation: 90, Duration: 5, FastStart: true},
+		},
+		{
+			name: "version 1 tkhd and mvhd",
+			file: concat(ftyp, mkBox("moov", mvhd64(90000, 90000*3), trak(tkhd(1, identity, 640, 360), "vide", stsd(640, 360))), mdat),
+			want: Info{Width: 640, Height: 360, Duration: 3, FastStart: true},
+		},
+		{
+			name: "64-bit top-level boxes",
+			file: concat(ftyp, mkBox64("mdat", make([]byte, 64)), mkBox64("moov", mvhd(1000, 2000), videoTrak(identity, 1280, 720))),
+			want: Info{Width: 1280, Height: 720, Duration: 2},
+		},
+		{
+			name: "64-bit boxes inside moov",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), mkBox64("trak", tkhd(0, identity, 1280, 720), mkBox64("mdia", hdlr("vide"), mkBox("minf", mkBox("stbl", stsd(1280, 720)))))), mdat),
+			want: Info{Width: 1280, Height: 720, Duration: 2, FastStart: true},
+		},
+		{
+			name: "trailing box running to the end of the file",
+			file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), videoTrak(identity, 1280, 720)), u32(0), []byte("mdat"), make([]byte, 64)),
+			want: Info{Width: 1280, Height: 720, Duration: 2, FastStart: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	moov := mkBox("moov", mvhd(1000, 2000), videoTrak(identity, 1280, 720))
+	tests := []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{name: "empty file", file: nil, want: ErrNotMP4},
+		{name: "not starting with ftyp", file: concat(mdat, ftyp, moov), want: ErrNotMP4},
+		{name: "truncated first header", file: ftyp[:5], want: ErrNotMP4},
+		{name: "truncated box header", file: concat(ftyp, moov[:6]), want: ErrUnsupported},
+		{name: "truncated largesize", file: concat(ftyp, u32(1), []byte("mdat"), u32(0)), want: ErrUnsupported},
+		{name: "largesize smaller than its header", file: concat(ftyp, u32(1), []byte("mdat"), u64(8)), want: ErrUnsupported},
+		{name: "box size smaller than its header", file: concat(ftyp, u32(4), []byte("free"), moov), want: ErrUnsupported},
+		{name: "truncated moov", file: concat(ftyp, moov[:len(moov)-10]), want: ErrUnsupported},
+		{name: "moov running to the end of the file", file: concat(ftyp, u32(0), moov[4:]), want: ErrUnsupported},
+		{name: "no moov", file: concat(ftyp, mdat), want: ErrUnsupported},
+		{name: "truncated child box", file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), videoTrak(identity, 1280, 720)[:20])), want: ErrUnsupported},
+		{name: "child box larger than its parent", file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), u32(1000), []byte("trak"))), want: ErrUnsupported},
+		{name: "truncated 64-bit child box", file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), u32(1), []byte("trak"), u32(0))), want: ErrUnsupported},
+		{name: "missing mvhd", file: concat(ftyp, mkBox("moov", videoTrak(identity, 1280, 720))), want: ErrUnsupported},
+		{name: "zero timescale", file: concat(ftyp, mkBox("moov", mvhd(0, 2000), videoTrak(identity, 1280, 720))), want: ErrUnsupported},
+		{name: "truncated tkhd", file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), trak(mkBox("tkhd", tkhdPayload(0, identity, 1280, 720)[:60]), "vide", stsd(1280, 720)))), want: ErrUnsupported},
+		{name: "no video track", file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), audioTrak())), want: ErrUnsupported},
+		{name: "video track without dimensions", file: concat(ftyp, mkBox("moov", mvhd(1000, 2000), trak(tkhd(0, identity, 0, 0), "vide", stsd(0, 0)))), want: ErrUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(bytes.NewReader(tt.file))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
@@ -21,6 +24,19 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	})
 }
 
+// respondWithDatabaseError responds to a failed database call: 404 when the
+// row doesn't exist, 409 when it clashes with another and 500 otherwise
+func respondWithDatabaseError(w http.ResponseWriter, msg string, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, database.ErrConflict):
+		code = http.StatusConflict
+	}
+	respondWithError(w, code, msg, err)
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
@@ -5,14 +5,16 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediatool"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
@@ -26,21 +28,65 @@ type apiConfig struct {
 	s3CfDistribution string
 	port             string
 	s3Client         *s3.Client
+	preview          previewConfig
+	audioMP3         bool
+	media            mediatool.Runner
+	// Bounds concurrent video processing, see processingLimiter
+	processing *processingLimiter
+	// Bounds concurrent thumbnail decoding, which can take hundreds of MB each
+	thumbnailProcessing  *processingLimiter
+	processingRetryAfter int
+	minFreeDisk          int64
+	pipeline             *pipeline
+	s3OriginalsBucket    string
+	adminAPIKey          string
+	// How many versions of a video's file are kept, the current one included
+	videoVersionRetention int
+	uploadPolicies        uploadPolicies
+	// How long deleted videos stay in the trash before they're purged
+	trashRetention time.Duration
+	// Reprocess jobs started through the admin API
+	reprocessJobs *reprocessJobs
 }
 
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
+	// A postgres:// URL or a SQLite file path, DB_PATH still works for the latter
+	dbURL := getEnvDefault("DB_URL", os.Getenv("DB_PATH"))
+	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	db, err := database.NewClient(dbURL)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
+	// How long a single query may run, 0 for no limit
+	dbQueryTimeout, err := time.ParseDuration(getEnvDefault("DB_QUERY_TIMEOUT", "30s"))
+	if err != nil || dbQueryTimeout < 0 {
+		log.Fatal("DB_QUERY_TIMEOUT must be a duration, e.g. 30s, or 0 for no limit")
+	}
+	db.SetQueryTimeout(dbQueryTimeout)
+
+	// The migrate subcommand reports on and applies migrations itself, and
+	// needs none of the server's config
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	dbAutoMigrate, err := strconv.ParseBool(getEnvDefault("DB_AUTO_MIGRATE", "true"))
+	if err != nil {
+		log.Fatal("DB_AUTO_MIGRATE must be true or false")
+	}
+	if err := migrateOnStart(db, dbAutoMigrate); err != nil {
+		log.Fatal(err)
+	}
+
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
@@ -76,11 +122,90 @@ func main() {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
+	// Originals are kept apart from the served files, in the main bucket
+	// unless told otherwise
+	s3OriginalsBucket := getEnvDefault("S3_ORIGINALS_BUCKET", s3Bucket)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	previewSeconds, err := strconv.ParseFloat(getEnvDefault("PREVIEW_SECONDS", "3"), 64)
+	if err != nil || previewSeconds <= 0 {
+		log.Fatal("PREVIEW_SECONDS must be a positive number")
+	}
+	previewFPS, err := strconv.Atoi(getEnvDefault("PREVIEW_FPS", "10"))
+	if err != nil || previewFPS <= 0 {
+		log.Fatal("PREVIEW_FPS must be a positive integer")
+	}
+	previewWidth, err := strconv.Atoi(getEnvDefault("PREVIEW_WIDTH", "320"))
+	if err != nil || previewWidth <= 0 || previewWidth%2 != 0 {
+		log.Fatal("PREVIEW_WIDTH must be a positive even integer")
+	}
+
+	audioMP3, err := strconv.ParseBool(getEnvDefault("AUDIO_MP3", "false"))
+	if err != nil {
+		log.Fatal("AUDIO_MP3 must be true or false")
+	}
+
+	mediaTimeout, err := time.ParseDuration(getEnvDefault("MEDIA_TIMEOUT", "30m"))
+	if err != nil {
+		log.Fatal("MEDIA_TIMEOUT must be a duration such as 30m")
+	}
+	mediaMaxFileSize, err := strconv.ParseInt(getEnvDefault("MEDIA_MAX_FILE_SIZE", "4294967296"), 10, 64)
+	if err != nil {
+		log.Fatal("MEDIA_MAX_FILE_SIZE must be a number of bytes")
+	}
+
+	processingConcurrency, err := strconv.Atoi(getEnvDefault("PROCESSING_CONCURRENCY", "2"))
+	if err != nil || processingConcurrency <= 0 {
+		log.Fatal("PROCESSING_CONCURRENCY must be a positive integer")
+	}
+	processingQueueSize, err := strconv.Atoi(getEnvDefault("PROCESSING_QUEUE_SIZE", "8"))
+	if err != nil || processingQueueSize < 0 {
+		log.Fatal("PROCESSING_QUEUE_SIZE must be a non-negative integer")
+	}
+	thumbnailConcurrency, err := strconv.Atoi(getEnvDefault("THUMBNAIL_CONCURRENCY", "2"))
+	if err != nil || thumbnailConcurrency <= 0 {
+		log.Fatal("THUMBNAIL_CONCURRENCY must be a positive integer")
+	}
+	processingRetryAfter, err := strconv.Atoi(getEnvDefault("PROCESSING_RETRY_AFTER", "30"))
+	if err != nil || processingRetryAfter <= 0 {
+		log.Fatal("PROCESSING_RETRY_AFTER must be a positive number of seconds")
+	}
+	minFreeDisk, err := strconv.ParseInt(getEnvDefault("MIN_FREE_DISK", "1073741824"), 10, 64)
+	if err != nil || minFreeDisk < 0 {
+		log.Fatal("MIN_FREE_DISK must be a number of bytes")
+	}
+
+	videoVersionRetention, err := strconv.Atoi(getEnvDefault("VIDEO_VERSION_RETENTION", "5"))
+	if err != nil || videoVersionRetention <= 0 {
+		log.Fatal("VIDEO_VERSION_RETENTION must be a positive integer")
+	}
+
+	trashRetention, err := time.ParseDuration(getEnvDefault("TRASH_RETENTION", "720h"))
+	if err != nil || trashRetention <= 0 {
+		log.Fatal("TRASH_RETENTION must be a positive duration, e.g. 720h")
+	}
+	trashPurgeInterval, err := time.ParseDuration(getEnvDefault("TRASH_PURGE_INTERVAL", "1h"))
+	if err != nil || trashPurgeInterval <= 0 {
+		log.Fatal("TRASH_PURGE_INTERVAL must be a positive duration, e.g. 1h")
+	}
+
+	// The admin endpoints are disabled when no key is set
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+
+	videoPipeline, err := loadPipeline(os.Getenv("PIPELINE_CONFIG"))
+	if err != nil {
+		log.Fatalf("Couldn't load processing pipeline: %v", err)
+	}
+
+	uploadPolicies, err := loadUploadPolicies(os.Getenv("UPLOAD_POLICIES"), mediaMaxFileSize)
+	if err != nil {
+		log.Fatalf("Couldn't load upload policies: %v", err)
+	}
+
 	// auto load the default AWS SDK config (the keys you set with aws configure)
 	awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
 	if err != nil {
@@ -99,6 +224,28 @@ func main() {
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
 		s3Client:         s3Client,
+		preview: previewConfig{
+			seconds: previewSeconds,
+			fps:     previewFPS,
+			width:   previewWidth,
+		},
+		audioMP3: audioMP3,
+		media: mediatool.ExecRunner{
+			Timeout:           mediaTimeout,
+			MaxFileSize:       mediaMaxFileSize,
+			MaxCapturedOutput: 16 << 20, // ffprobe JSON and error output stay small
+		},
+		processing:            newProcessingLimiter(processingConcurrency, processingQueueSize),
+		thumbnailProcessing:   newProcessingLimiter(thumbnailConcurrency, processingQueueSize),
+		processingRetryAfter:  processingRetryAfter,
+		minFreeDisk:           minFreeDisk,
+		pipeline:              videoPipeline,
+		s3OriginalsBucket:     s3OriginalsBucket,
+		adminAPIKey:           adminAPIKey,
+		videoVersionRetention: videoVersionRetention,
+		uploadPolicies:        uploadPolicies,
+		trashRetention:        trashRetention,
+		reprocessJobs:         newReprocessJobs(),
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -106,6 +253,14 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	// Run an admin subcommand, e.g. "tubely reprocess -outdated", instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(&cfg, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
@@ -118,21 +273,65 @@ func main() {
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("DELETE /api/users/me", cfg.handlerUsersDelete)
+	mux.HandleFunc("GET /api/users/me/usage", cfg.handlerUsageGet)
+	mux.HandleFunc("GET /api/users/me/tags", cfg.handlerTagsList)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/duplicates", cfg.handlerLibraryDuplicates)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideoSearch)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("PUT /api/videos/{videoID}/chapters", cfg.handlerChaptersUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/chapters.vtt", cfg.handlerChaptersVTT)
+	mux.HandleFunc("GET /api/videos/{videoID}/tags", cfg.handlerVideoTagsGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/tags", cfg.handlerVideoTagsUpdate)
+	mux.HandleFunc("PUT /api/videos/{videoID}/category", cfg.handlerVideoCategoryUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/duplicates", cfg.handlerVideoDuplicates)
+	mux.HandleFunc("GET /api/videos/{videoID}/versions", cfg.handlerVideoVersionsList)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/versions", cfg.handlerVideoVersionsPurge)
+	mux.HandleFunc("POST /api/videos/{videoID}/versions/{versionID}/restore", cfg.handlerVideoVersionRestore)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/versions/{versionID}", cfg.handlerVideoVersionDelete)
+
+	mux.HandleFunc("GET /api/trash", cfg.handlerTrashList)
+	mux.HandleFunc("POST /api/trash/{videoID}/restore", cfg.handlerTrashRestore)
+	mux.HandleFunc("DELETE /api/trash/{videoID}", cfg.handlerTrashPurge)
+
+	mux.HandleFunc("POST /api/playlists", cfg.handlerPlaylistCreate)
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsList)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+	mux.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideoAdd)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.handlerPlaylistVideoRemove)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/order", cfg.handlerPlaylistReorder)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("POST /admin/reprocess", cfg.handlerReprocess)
+	mux.HandleFunc("GET /admin/reprocess/{jobID}", cfg.handlerReprocessJob)
+	mux.HandleFunc("PUT /admin/users/{userID}/tier", cfg.handlerUserTierUpdate)
+	mux.HandleFunc("GET /admin/storage", cfg.handlerStorageReport)
+	mux.HandleFunc("GET /admin/audit", cfg.handlerAuditEvents)
+	mux.HandleFunc("GET /admin/audit/export", cfg.handlerAuditExport)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
 
+	go cfg.runTrashPurge(context.Background(), trashPurgeInterval)
+
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+// getEnvDefault returns the value of an optional environment variable
+func getEnvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
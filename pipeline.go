@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+	"github.com/google/uuid"
+)
+
+// videoJob carries one video through the processing pipeline. Steps read what
+// earlier steps filled in and add their own results.
+type videoJob struct {
+	video        database.Video
+	inputPath    string
+	mediaType    string
+	verticalMode string
+	// Set when the input is the stored original of an earlier upload
+	reprocessing bool
+	// Who uploaded the file, its size and SHA-256, recorded on the new version
+	uploaderID uuid.UUID
+	size       int64
+	checksum   string
+	// The uploader's tier and its limits, left empty when reprocessing
+	tier   string
+	policy uploadPolicy
+
+	// Set by the probe step
+	info        mp4.Info
+	parsed      bool // info was read from the MP4 boxes
+	aspectRatio string
+	duration    float64
+
+	// Set by the transcode step, empty when the input is used as is
+	processedPath string
+	// S3 key of the main video, set by the upload step
+	key string
+	// The owner's videos this one likely duplicates, set by the fingerprint step
+	possibleDuplicates []duplicateMatch
+
+	// Temp files to remove once the job is done
+	tempFiles []string
+	// Files the job stored, deleted again if the pipeline fails before saveStep
+	// has the database refer to them
+	storedFiles []database.StoredObject
+	saved       bool
+}
+
+// videoPath returns the file the derivative steps should work from
+func (job *videoJob) videoPath() string {
+	if job.processedPath != "" {
+		return job.processedPath
+	}
+	return job.inputPath
+}
+
+func (job *videoJob) addTempFile(path string) {
+	job.tempFiles = append(job.tempFiles, path)
+}
+
+// owner attributes a file stored for the job to its video and remembers it
+func (job *videoJob) owner(category string) objectOwner {
+	owner := videoOwner(job.video, category)
+	owner.stored = &job.storedFiles
+	return owner
+}
+
+// deleteStoredFiles removes what the job stored before it failed
+func (job *videoJob) deleteStoredFiles(ctx context.Context, cfg *apiConfig) {
+	if job.saved {
+		return
+	}
+	for _, object := range job.storedFiles {
+		if err := cfg.deleteStoredObject(ctx, object); err != nil {
+			log.Printf("Couldn't delete %s/%s of failed video %s: %v", object.Location, object.Key, job.video.ID, err)
+		}
+	}
+	job.storedFiles = nil
+}
+
+func (job *videoJob) removeTempFiles() {
+	for _, path := range job.tempFiles {
+		os.Remove(path)
+	}
+}
+
+// pipelineStep is one unit of video processing
+type pipelineStep interface {
+	Run(ctx context.Context, cfg *apiConfig, job *videoJob) error
+}
+
+// pipelineStepFactories lists every step a pipeline config can refer to. The
+// options are the step's "options" object from the config file, if any.
+var pipelineStepFactories = map[string]func(options json.RawMessage) (pipelineStep, error){
+	"probe":       func(json.RawMessage) (pipelineStep, error) { return probeStep{}, nil },
+	"validate":    func(json.RawMessage) (pipelineStep, error) { return validateStep{}, nil },
+	"original":    func(json.RawMessage) (pipelineStep, error) { return originalStep{}, nil },
+	"fingerprint": func(json.RawMessage) (pipelineStep, error) { return fingerprintStep{}, nil },
+	"transcode":   func(json.RawMessage) (pipelineStep, error) { return transcodeStep{}, nil },
+	"upload":      func(json.RawMessage) (pipelineStep, error) { return uploadStep{}, nil },
+	"vertical":    func(json.RawMessage) (pipelineStep, error) { return verticalStep{}, nil },
+	"audio":       func(json.RawMessage) (pipelineStep, error) { return audioStep{}, nil },
+	"preview":     func(json.RawMessage) (pipelineStep, error) { return previewStep{}, nil },
+	"thumbnail":   func(json.RawMessage) (pipelineStep, error) { return thumbnailStep{}, nil },
+	"save":        func(json.RawMessage) (pipelineStep, error) { return saveStep{}, nil },
+	"notify":      newNotifyStep,
+}
+
+// Steps the rest of the pipeline can't do without
+var requiredPipelineSteps = []string{"probe", "upload", "save"}
+
+type pipelineStepConfig struct {
+	Name string `json:"name"`
+	// Defaults to true
+	Enabled *bool `json:"enabled"`
+	// A failing optional step is logged and the pipeline carries on
+	Optional bool            `json:"optional"`
+	Options  json.RawMessage `json:"options"`
+}
+
+type pipelineConfig struct {
+	// Deployments that change their step config should bump the version so
+	// existing videos can be reprocessed, defaults to pipelineVersion
+	Version int                  `json:"version"`
+	Steps   []pipelineStepConfig `json:"steps"`
+}
+
+// pipelineVersion is recorded on every processed video. Bump it whenever a
+// step changes what it produces.
+const pipelineVersion = 1
+
+var defaultPipelineConfig = pipelineConfig{
+	Steps: []pipelineStepConfig{
+		{Name: "probe"},
+		{Name: "validate"},
+		{Name: "original"},
+		{Name: "transcode"},
+		{Name: "upload"},
+		{Name: "vertical", Optional: true},
+		{Name: "audio", Optional: true},
+		{Name: "preview", Optional: true},
+		{Name: "thumbnail", Optional: true},
+		{Name: "fingerprint", Optional: true},
+		{Name: "save"},
+	},
+}
+
+type configuredStep struct {
+	name     string
+	optional bool
+	step     pipelineStep
+}
+
+type pipeline struct {
+	version int
+	steps   []configuredStep
+}
+
+// loadPipeline builds the pipeline described by the JSON config file at path,
+// or the default pipeline when path is empty
+func loadPipeline(path string) (*pipeline, error) {
+	config := defaultPipelineConfig
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read pipeline config: %w", err)
+		}
+		config = pipelineConfig{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("couldn't parse pipeline config: %w", err)
+		}
+	}
+	return newPipeline(config)
+}
+
+func newPipeline(config pipelineConfig) (*pipeline, error) {
+	p := &pipeline{version: config.Version}
+	if p.version == 0 {
+		p.version = pipelineVersion
+	}
+	seen := map[string]bool{}
+	enabled := map[string]bool{}
+	for _, stepConfig := range config.Steps {
+		factory, ok := pipelineStepFactories[stepConfig.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pipeline step %q", stepConfig.Name)
+		}
+		if seen[stepConfig.Name] {
+			return nil, fmt.Errorf("pipeline step %q is listed twice", stepConfig.Name)
+		}
+		seen[stepConfig.Name] = true
+		if stepConfig.Enabled != nil && !*stepConfig.Enabled {
+			continue
+		}
+		step, err := factory(stepConfig.Options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for pipeline step %q: %w", stepConfig.Name, err)
+		}
+		enabled[stepConfig.Name] = true
+		p.steps = append(p.steps, configuredStep{
+			name:     stepConfig.Name,
+			optional: stepConfig.Optional,
+			step:     step,
+		})
+	}
+	for _, name := range requiredPipelineSteps {
+		if !enabled[name] {
+			return nil, fmt.Errorf("pipeline step %q is required", name)
+		}
+	}
+	return p, nil
+}
+
+type stepReport struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// pipelineError is returned when a required step fails
+type pipelineError struct {
+	step string
+	err  error
+}
+
+func (e *pipelineError) Error() string {
+	return fmt.Sprintf("pipeline step %s failed: %v", e.step, e.err)
+}
+
+func (e *pipelineError) Unwrap() error {
+	return e.err
+}
+
+// run passes the job through every enabled step in order, stopping at the
+// first required step that fails and deleting the files stored until then.
+// Each step's timing and error are logged and returned.
+func (p *pipeline) run(ctx context.Context, cfg *apiConfig, job *videoJob) ([]stepReport, error) {
+	reports := []stepReport{}
+	for _, s := range p.steps {
+		start := time.Now()
+		err := s.step.Run(ctx, cfg, job)
+		report := stepReport{Name: s.name, Duration: time.Since(start), Err: err}
+		reports = append(reports, report)
+
+		if err == nil {
+			log.Printf("Pipeline step %s for video %s took %s", s.name, job.video.ID, report.Duration.Round(time.Millisecond))
+			continue
+		}
+		log.Printf("Pipeline step %s for video %s failed after %s: %v", s.name, job.video.ID, report.Duration.Round(time.Millisecond), err)
+		if !s.optional || errors.Is(err, context.Canceled) {
+			job.deleteStoredFiles(context.WithoutCancel(ctx), cfg)
+			return reports, &pipelineError{step: s.name, err: err}
+		}
+	}
+	return reports, nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+	"github.com/google/uuid"
+)
+
+// probeStep reads the video's dimensions and duration, straight from the MP4
+// boxes when possible and with ffprobe otherwise
+type probeStep struct{}
+
+func (probeStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	file, err := os.Open(job.inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	job.info, err = mp4.Parse(file)
+	if err != nil {
+		log.Printf("Couldn't parse MP4 boxes of video %s, falling back to ffprobe: %v", job.video.ID, err)
+		job.info.Width, job.info.Height, err = cfg.getVideoDimensions(ctx, job.inputPath)
+		if err != nil {
+			return fmt.Errorf("error determining dimensions: %w", err)
+		}
+		job.aspectRatio = aspectRatioFromDimensions(job.info.Width, job.info.Height)
+		job.duration, err = cfg.getVideoDuration(ctx, job.inputPath)
+		if err != nil {
+			return fmt.Errorf("error determining duration: %w", err)
+		}
+	} else {
+		job.parsed = true
+		job.aspectRatio = aspectRatioFromDimensions(job.info.Width, job.info.Height)
+		job.duration = job.info.Duration
+	}
+
+	job.video.Duration = &job.duration
+	return nil
+}
+
+// validateStep rejects files that probed fine but can't be used, or that go
+// over the uploader's tier limits
+type validateStep struct{}
+
+func (validateStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	if job.duration <= 0 {
+		return errors.New("video has no duration")
+	}
+	return job.policy.checkProbed(job.tier, job.duration, job.info.Width, job.info.Height)
+}
+
+// fingerprintStep stores a perceptual fingerprint of the video and notes the
+// owner's videos it likely duplicates
+type fingerprintStep struct{}
+
+func (fingerprintStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	fingerprint, err := cfg.computeFingerprint(ctx, job.inputPath, job.duration)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.SetFingerprintContext(ctx, job.video.ID, fingerprint)
+	if err != nil {
+		return fmt.Errorf("couldn't save fingerprint: %w", err)
+	}
+
+	candidates, err := cfg.db.GetUserFingerprintsContext(ctx, job.video.UserID)
+	if err != nil {
+		return fmt.Errorf("couldn't look up fingerprints: %w", err)
+	}
+	job.possibleDuplicates = findDuplicates(job.video.ID, fingerprint, candidates)
+	return nil
+}
+
+// originalStep keeps the untouched upload in the originals bucket so the video
+// can be reprocessed when the pipeline improves
+type originalStep struct{}
+
+func (originalStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	if job.reprocessing {
+		return nil
+	}
+	key := path.Join("originals", job.video.ID.String(), getAssetPath(job.mediaType))
+	err := cfg.putFileInBucket(ctx, cfg.s3OriginalsBucket, job.inputPath, key, job.mediaType, job.owner(storageOriginals))
+	if err != nil {
+		return fmt.Errorf("error storing original file: %w", err)
+	}
+	job.video.OriginalKey = &key
+	return nil
+}
+
+// transcodeStep remuxes the video for fast start and embeds its chapters,
+// unless the upload is already laid out that way and has no chapters
+type transcodeStep struct{}
+
+func (transcodeStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	if job.parsed && job.info.FastStart && len(job.video.Chapters) == 0 {
+		return nil
+	}
+	processedFilePath, err := cfg.processVideoForFastStart(ctx, job.inputPath, job.video.Chapters, job.duration)
+	if err != nil {
+		return err
+	}
+	job.addTempFile(processedFilePath)
+	job.processedPath = processedFilePath
+	return nil
+}
+
+// uploadStep puts the video into S3 under a prefix matching its orientation
+type uploadStep struct{}
+
+func (uploadStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	directory := ""
+	switch job.aspectRatio {
+	case "16:9":
+		directory = "landscape"
+	case "9:16":
+		directory = "portrait"
+	default:
+		directory = "other"
+	}
+
+	key := getAssetPath(job.mediaType)
+	key = path.Join(directory, key) // The file name using <random-32-byte-hex>.ext format
+	err := cfg.putFileInS3(ctx, job.videoPath(), key, job.mediaType, job.owner(storageVideos))
+	if err != nil {
+		return fmt.Errorf("error uploading file to S3: %w", err)
+	}
+
+	// Update the VideoURL of the video record with the cloudfront URL
+	job.key = key
+	videoURL := cfg.getCloudFrontURL(key)
+	job.video.VideoURL = &videoURL
+	return nil
+}
+
+// verticalStep generates the 9:16 rendition of landscape videos when the
+// uploader asked for one and stores it under the portrait prefix
+type verticalStep struct{}
+
+func (verticalStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	if job.verticalMode == "" || job.aspectRatio != "16:9" {
+		return nil
+	}
+	verticalFilePath, err := cfg.processVideoForVertical(ctx, job.inputPath, job.verticalMode)
+	if err != nil {
+		return err
+	}
+	job.addTempFile(verticalFilePath)
+
+	verticalKey := path.Join("portrait", getAssetPath(job.mediaType))
+	err = cfg.putFileInS3(ctx, verticalFilePath, verticalKey, job.mediaType, job.owner(storageDerivatives))
+	if err != nil {
+		return fmt.Errorf("error uploading vertical file to S3: %w", err)
+	}
+
+	verticalURL := cfg.getCloudFrontURL(verticalKey)
+	job.video.VerticalVideoURL = &verticalURL
+	return nil
+}
+
+// audioStep extracts the audio-only renditions and stores them next to the video
+type audioStep struct{}
+
+func (audioStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	audioCodec, err := cfg.getAudioCodec(ctx, job.videoPath())
+	if err != nil {
+		return err
+	}
+	if audioCodec == "" {
+		return nil
+	}
+
+	audioFilePath, err := cfg.processVideoForAudio(ctx, job.videoPath(), audioCodec)
+	if err != nil {
+		return err
+	}
+	job.addTempFile(audioFilePath)
+
+	audioInfo, err := os.Stat(audioFilePath)
+	if err != nil {
+		return fmt.Errorf("could not stat audio file: %w", err)
+	}
+	audioDuration, err := cfg.getVideoDuration(ctx, audioFilePath)
+	if err != nil {
+		return fmt.Errorf("error determining audio duration: %w", err)
+	}
+
+	baseKey := strings.TrimSuffix(job.key, path.Ext(job.key))
+	audioKey := baseKey + ".m4a"
+	err = cfg.putFileInS3(ctx, audioFilePath, audioKey, "audio/mp4", job.owner(storageDerivatives))
+	if err != nil {
+		return fmt.Errorf("error uploading audio file to S3: %w", err)
+	}
+	audioURL := cfg.getCloudFrontURL(audioKey)
+	audioSize := audioInfo.Size()
+	job.video.AudioURL = &audioURL
+	job.video.AudioSize = &audioSize
+	job.video.AudioDuration = &audioDuration
+
+	if !cfg.audioMP3 {
+		return nil
+	}
+	mp3FilePath, err := cfg.processVideoForMP3(ctx, job.videoPath())
+	if err != nil {
+		return err
+	}
+	job.addTempFile(mp3FilePath)
+
+	mp3Key := baseKey + ".mp3"
+	err = cfg.putFileInS3(ctx, mp3FilePath, mp3Key, "audio/mpeg", job.owner(storageDerivatives))
+	if err != nil {
+		return fmt.Errorf("error uploading MP3 file to S3: %w", err)
+	}
+	mp3URL := cfg.getCloudFrontURL(mp3Key)
+	job.video.AudioMP3URL = &mp3URL
+	return nil
+}
+
+// previewStep renders the animated hover previews
+type previewStep struct{}
+
+func (previewStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	webpPath, mp4Path, err := cfg.generatePreviews(ctx, job.inputPath, job.duration, job.owner(storageDerivatives))
+	if err != nil {
+		return err
+	}
+	previewURL := cfg.getAssetURL(webpPath)
+	previewMP4URL := cfg.getAssetURL(mp4Path)
+	job.video.PreviewURL = &previewURL
+	job.video.PreviewMP4URL = &previewMP4URL
+	return nil
+}
+
+// thumbnailStep grabs a frame as the thumbnail of videos that don't have one yet
+type thumbnailStep struct{}
+
+func (thumbnailStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	if job.video.ThumbnailURL != nil {
+		return nil
+	}
+
+	framePath := fmt.Sprintf("%s.frame.png", job.inputPath)
+	job.addTempFile(framePath)
+	// A tenth of the way in skips past black intro frames
+	seek := fmt.Sprintf("%f", job.duration/10)
+	_, err := cfg.media.Run(ctx, "ffmpeg", "-ss", seek, "-i", job.inputPath, "-frames:v", "1", "-f", "image2", framePath)
+	if err != nil {
+		return fmt.Errorf("error extracting thumbnail frame: %w", err)
+	}
+
+	frame, err := os.Open(framePath)
+	if err != nil {
+		return err
+	}
+	defer frame.Close()
+	frameConfig, _, err := image.DecodeConfig(frame)
+	if err != nil {
+		return fmt.Errorf("could not decode thumbnail frame: %w", err)
+	}
+
+	srcset, err := cfg.generateThumbnailVariants(ctx, framePath, frameConfig.Width, job.owner(storageThumbnails))
+	if err != nil {
+		return err
+	}
+	url := largestSrcsetURL(srcset["image/jpeg"])
+	job.video.ThumbnailURL = &url
+	job.video.ThumbnailSrcset = srcset
+	return nil
+}
+
+// saveStep stores the processed video's record in the database, along with a
+// new version of its files. Reprocessing updates the files of the current
+// version instead.
+type saveStep struct{}
+
+func (saveStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	version := cfg.pipeline.version
+	job.video.PipelineVersion = &version
+	if job.verticalMode != "" {
+		job.video.VerticalMode = &job.verticalMode
+	} else {
+		job.video.VerticalMode = nil
+	}
+
+	if job.reprocessing {
+		// The files of the version as it was, deleted once the new ones are saved
+		var replaced *database.VideoVersion
+		if job.video.CurrentVersionID != nil {
+			current, err := cfg.db.GetVideoVersionContext(ctx, *job.video.CurrentVersionID)
+			if err != nil {
+				return fmt.Errorf("couldn't get video version: %w", err)
+			}
+			replaced = &current
+		}
+		// Only the files change, so edits made while the video was being
+		// reprocessed are kept
+		err := cfg.db.ReplaceVideoFilesContext(ctx, job.video, job.key)
+		if err != nil {
+			return fmt.Errorf("couldn't save reprocessed files: %w", err)
+		}
+		job.saved = true
+		if replaced != nil {
+			// Unchanged files, such as the original, are still in use
+			inUse, err := cfg.versionsInUse(ctx, job.video.ID, uuid.Nil)
+			if err == nil {
+				err = cfg.deleteVersionFiles(ctx, *replaced, inUse)
+			}
+			if err != nil {
+				log.Printf("Couldn't delete replaced files of video %s: %v", job.video.ID, err)
+			}
+		}
+	} else {
+		videoVersion, err := cfg.db.CreateVideoVersionContext(ctx, database.CreateVideoVersionParams{
+			VideoID:    job.video.ID,
+			ObjectKey:  job.key,
+			Checksum:   job.checksum,
+			Size:       job.size,
+			Files:      job.video.Files(),
+			UploaderID: job.uploaderID,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't create video version: %w", err)
+		}
+		job.saved = true
+		job.video.CurrentVersionID = &videoVersion.ID
+
+		err = cfg.db.UpdateVideoContext(ctx, job.video)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Old versions past the retention count aren't worth failing the upload over
+	if _, err := cfg.pruneVideoVersions(ctx, job.video, cfg.videoVersionRetention-1); err != nil {
+		log.Printf("Couldn't prune old versions of video %s: %v", job.video.ID, err)
+	}
+	return nil
+}
+
+// notifyStep posts the processed video to a webhook
+type notifyStep struct {
+	url     string
+	timeout time.Duration
+}
+
+func newNotifyStep(options json.RawMessage) (pipelineStep, error) {
+	var params struct {
+		URL     string `json:"url"`
+		Timeout string `json:"timeout"`
+	}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &params); err != nil {
+			return nil, err
+		}
+	}
+	if params.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	step := notifyStep{url: params.URL, timeout: 10 * time.Second}
+	if params.Timeout != "" {
+		timeout, err := time.ParseDuration(params.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		step.timeout = timeout
+	}
+	return step, nil
+}
+
+func (s notifyStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	type payload struct {
+		Event string      `json:"event"`
+		Video interface{} `json:"video"`
+	}
+	body, err := json.Marshal(payload{Event: "video.processed", Video: job.video})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
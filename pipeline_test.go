@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stepNames(p *pipeline) []string {
+	names := []string{}
+	for _, s := range p.steps {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+func TestLoadPipeline(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		wantSteps   []string
+		wantVersion int
+		wantErr     string
+	}{
+		{
+			name:        "default",
+			wantSteps:   []string{"probe", "validate", "original", "transcode", "upload", "vertical", "audio", "preview", "thumbnail", "fingerprint", "save"},
+			wantVersion: pipelineVersion,
+		},
+		{
+			name:        "keeps the configured order",
+			config:      `{"version": 3, "steps": [{"name": "probe"}, {"name": "upload"}, {"name": "thumbnail"}, {"name": "audio"}, {"name": "save"}]}`,
+			wantSteps:   []string{"probe", "upload", "thumbnail", "audio", "save"},
+			wantVersion: 3,
+		},
+		{
+			name:        "skips disabled steps",
+			config:      `{"steps": [{"name": "probe"}, {"name": "vertical", "enabled": false}, {"name": "upload"}, {"name": "save", "enabled": true}]}`,
+			wantSteps:   []string{"probe", "upload", "save"},
+			wantVersion: pipelineVersion,
+		},
+		{
+			name:        "step options",
+			config:      `{"steps": [{"name": "probe"}, {"name": "upload"}, {"name": "save"}, {"name": "notify", "optional": true, "options": {"url": "http://localhost/hook", "timeout": "2s"}}]}`,
+			wantSteps:   []string{"probe", "upload", "save", "notify"},
+			wantVersion: pipelineVersion,
+		},
+		{
+			name:    "invalid JSON",
+			config:  `{"steps": [`,
+			wantErr: "couldn't parse pipeline config",
+		},
+		{
+			name:    "unknown step",
+			config:  `{"steps": [{"name": "probe"}, {"name": "upload"}, {"name": "save"}, {"name": "watermark"}]}`,
+			wantErr: `unknown pipeline step "watermark"`,
+		},
+		{
+			name:    "duplicate step",
+			config:  `{"steps": [{"name": "probe"}, {"name": "upload"}, {"name": "audio"}, {"name": "audio"}, {"name": "save"}]}`,
+			wantErr: `pipeline step "audio" is listed twice`,
+		},
+		{
+			name:    "duplicate disabled step",
+			config:  `{"steps": [{"name": "probe"}, {"name": "upload"}, {"name": "audio", "enabled": false}, {"name": "audio"}, {"name": "save"}]}`,
+			wantErr: `pipeline step "audio" is listed twice`,
+		},
+		{
+			name:    "missing required step",
+			config:  `{"steps": [{"name": "probe"}, {"name": "save"}]}`,
+			wantErr: `pipeline step "upload" is required`,
+		},
+		{
+			name:    "disabled required step",
+			config:  `{"steps": [{"name": "probe"}, {"name": "upload"}, {"name": "save", "enabled": false}]}`,
+			wantErr: `pipeline step "save" is required`,
+		},
+		{
+			name:    "invalid step options",
+			config:  `{"steps": [{"name": "probe"}, {"name": "upload"}, {"name": "save"}, {"name": "notify"}]}`,
+			wantErr: `invalid options for pipeline step "notify"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.config != "" {
+				path = filepath.Join(t.TempDir(), "pipeline.json")
+				if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p, err := loadPipeline(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadPipeline: %v", err)
+			}
+			if got := stepNames(p); !reflect.DeepEqual(got, tt.wantSteps) {
+				t.Errorf("got steps %v, want %v", got, tt.wantSteps)
+			}
+			if p.version != tt.wantVersion {
+				t.Errorf("got version %d, want %d", p.version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestDefaultPipelineOptionalSteps(t *testing.T) {
+	p, err := newPipeline(defaultPipelineConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOptional := map[string]bool{
+		"vertical":    true,
+		"audio":       true,
+		"preview":     true,
+		"thumbnail":   true,
+		"fingerprint": true,
+	}
+	for _, s := range p.steps {
+		if s.optional != wantOptional[s.name] {
+			t.Errorf("step %s: got optional %v, want %v", s.name, s.optional, wantOptional[s.name])
+		}
+	}
+}
+
+type fakeStep struct {
+	name string
+	err  error
+	ran  *[]string
+}
+
+func (s fakeStep) Run(ctx context.Context, cfg *apiConfig, job *videoJob) error {
+	*s.ran = append(*s.ran, s.name)
+	return s.err
+}
+
+func TestPipelineRun(t *testing.T) {
+	errStep := errors.New("step failed")
+	tests := []struct {
+		name     string
+		errs     map[string]error
+		optional map[string]bool
+		wantRan  []string
+		wantStep string // the step the pipeline stopped at, if any
+	}{
+		{
+			name:    "all steps succeed",
+			wantRan: []string{"probe", "upload", "audio", "save"},
+		},
+		{
+			name:     "optional step fails",
+			errs:     map[string]error{"audio": errStep},
+			optional: map[string]bool{"audio": true},
+			wantRan:  []string{"probe", "upload", "audio", "save"},
+		},
+		{
+			name:     "required step fails",
+			errs:     map[string]error{"upload": errStep},
+			wantRan:  []string{"probe", "upload"},
+			wantStep: "upload",
+		},
+		{
+			name:     "optional step canceled",
+			errs:     map[string]error{"audio": context.Canceled},
+			optional: map[string]bool{"audio": true},
+			wantRan:  []string{"probe", "upload", "audio"},
+			wantStep: "audio",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := []string{}
+			p := &pipeline{version: pipelineVersion}
+			for _, name := range []string{"probe", "upload", "audio", "save"} {
+				p.steps = append(p.steps, configuredStep{
+					name:     name,
+					optional: tt.optional[name],
+					step:     fakeStep{name: name, err: tt.errs[name], ran: &ran},
+				})
+			}
+
+			reports, err := p.run(context.Background(), &apiConfig{}, &videoJob{})
+			if !reflect.DeepEqual(ran, tt.wantRan) {
+				t.Errorf("got steps run %v, want %v", ran, tt.wantRan)
+			}
+			if len(reports) != len(tt.wantRan) {
+				t.Errorf("got %d reports, want %d", len(reports), len(tt.wantRan))
+			}
+			for _, report := range reports {
+				if !errors.Is(report.Err, tt.errs[report.Name]) {
+					t.Errorf("step %s: got report error %v, want %v", report.Name, report.Err, tt.errs[report.Name])
+				}
+			}
+
+			if tt.wantStep == "" {
+				if err != nil {
+					t.Fatalf("run: %v", err)
+				}
+				return
+			}
+			var pipelineErr *pipelineError
+			if !errors.As(err, &pipelineErr) || pipelineErr.step != tt.wantStep {
+				t.Fatalf("got error %v, want a failure of step %s", err, tt.wantStep)
+			}
+			if !errors.Is(err, tt.errs[tt.wantStep]) {
+				t.Errorf("got error %v, want it to wrap %v", err, tt.errs[tt.wantStep])
+			}
+		})
+	}
+}
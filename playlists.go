@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Private playlists are only shown to their owner, unlisted and public ones
+// to anyone with the ID
+var playlistVisibilities = []string{"private", "unlisted", "public"}
+
+const defaultPlaylistVisibility = "private"
+
+func validatePlaylist(playlist database.CreatePlaylistParams) error {
+	if strings.TrimSpace(playlist.Title) == "" {
+		return errors.New("playlist title can't be empty")
+	}
+	if !slices.Contains(playlistVisibilities, playlist.Visibility) {
+		return errors.New("visibility must be private, unlisted or public")
+	}
+	return nil
+}
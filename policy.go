@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Tier new users start on, matching the column default
+const defaultUserTier = "free"
+
+// Containers the pipeline knows how to process
+var supportedContainers = []string{"video/mp4"}
+
+// Video size limit of tiers that don't set max_file_size
+const defaultMaxFileSize = 1 << 30 // 1GB
+
+// uploadPolicy holds the upload limits of one user tier. Zero values mean no
+// limit, except for max_file_size which falls back to defaultMaxFileSize, and
+// an empty container list allows every supported container.
+type uploadPolicy struct {
+	MaxFileSize       int64    `json:"max_file_size"`
+	MaxThumbnailSize  int64    `json:"max_thumbnail_size"`
+	MaxDuration       float64  `json:"max_duration"`
+	MaxWidth          int      `json:"max_width"`
+	MaxHeight         int      `json:"max_height"`
+	AllowedContainers []string `json:"allowed_containers"`
+	MaxVideos         int      `json:"max_videos"`
+	// Bytes a user may store across videos, originals, thumbnails and derivatives
+	StorageQuota int64 `json:"storage_quota"`
+}
+
+type uploadPolicies struct {
+	Tiers map[string]uploadPolicy `json:"tiers"`
+}
+
+var defaultUploadPolicies = uploadPolicies{
+	Tiers: map[string]uploadPolicy{
+		defaultUserTier: {
+			MaxThumbnailSize: 10 << 20, // 10MB
+		},
+	},
+}
+
+// loadUploadPolicies reads the tier policies from the JSON file at path, or
+// returns the defaults when path is empty. Video size limits can't exceed
+// mediaMaxFileSize, the largest file ffmpeg may write, unless that is zero.
+func loadUploadPolicies(path string, mediaMaxFileSize int64) (uploadPolicies, error) {
+	if path == "" {
+		policies := uploadPolicies{Tiers: maps.Clone(defaultUploadPolicies.Tiers)}
+		return limitFileSizes(policies, mediaMaxFileSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return uploadPolicies{}, fmt.Errorf("couldn't read upload policies: %w", err)
+	}
+	policies := uploadPolicies{}
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return uploadPolicies{}, fmt.Errorf("couldn't parse upload policies: %w", err)
+	}
+
+	if _, ok := policies.Tiers[defaultUserTier]; !ok {
+		return uploadPolicies{}, fmt.Errorf("upload policies must include the %q tier", defaultUserTier)
+	}
+	for tier, policy := range policies.Tiers {
+		if policy.MaxFileSize < 0 || policy.MaxThumbnailSize < 0 || policy.MaxDuration < 0 ||
+			policy.MaxWidth < 0 || policy.MaxHeight < 0 || policy.MaxVideos < 0 || policy.StorageQuota < 0 {
+			return uploadPolicies{}, fmt.Errorf("limits of tier %q can't be negative", tier)
+		}
+		if (policy.MaxWidth > 0) != (policy.MaxHeight > 0) {
+			return uploadPolicies{}, fmt.Errorf("tier %q must set both max_width and max_height", tier)
+		}
+		for _, container := range policy.AllowedContainers {
+			if !slices.Contains(supportedContainers, container) {
+				return uploadPolicies{}, fmt.Errorf("tier %q allows unsupported container %q", tier, container)
+			}
+		}
+	}
+	return limitFileSizes(policies, mediaMaxFileSize)
+}
+
+// limitFileSizes gives tiers without max_file_size the default limit, or the
+// media limit when that is lower, and rejects tiers whose videos the media
+// tools couldn't write back out
+func limitFileSizes(policies uploadPolicies, mediaMaxFileSize int64) (uploadPolicies, error) {
+	defaultLimit := int64(defaultMaxFileSize)
+	if mediaMaxFileSize > 0 {
+		defaultLimit = min(defaultLimit, mediaMaxFileSize)
+	}
+	for tier, policy := range policies.Tiers {
+		if policy.MaxFileSize == 0 {
+			policy.MaxFileSize = defaultLimit
+			policies.Tiers[tier] = policy
+		}
+		if mediaMaxFileSize > 0 && policy.MaxFileSize > mediaMaxFileSize {
+			return uploadPolicies{}, fmt.Errorf("max_file_size of tier %q (%s) is above MEDIA_MAX_FILE_SIZE (%s)",
+				tier, formatFileSize(policy.MaxFileSize), formatFileSize(mediaMaxFileSize))
+		}
+	}
+	return policies, nil
+}
+
+// policyFor returns the policy of a tier, falling back to the default tier
+// for tiers that are no longer configured
+func (p uploadPolicies) policyFor(tier string) uploadPolicy {
+	policy, ok := p.Tiers[tier]
+	if !ok {
+		log.Printf("Upload policy tier %q isn't configured, using %q", tier, defaultUserTier)
+		return p.Tiers[defaultUserTier]
+	}
+	return policy
+}
+
+// userUploadPolicy looks up the policy of the user's tier
+func (cfg *apiConfig) userUploadPolicy(ctx context.Context, userID uuid.UUID) (string, uploadPolicy, error) {
+	user, err := cfg.db.GetUserContext(ctx, userID)
+	if err != nil {
+		return "", uploadPolicy{}, err
+	}
+	return user.Tier, cfg.uploadPolicies.policyFor(user.Tier), nil
+}
+
+// policyViolation is returned when an upload exceeds one of its tier's limits
+type policyViolation struct {
+	status  int
+	message string
+}
+
+func (e *policyViolation) Error() string {
+	return e.message
+}
+
+func newPolicyViolation(status int, format string, args ...any) *policyViolation {
+	return &policyViolation{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// respondWithPolicyViolation sends a violation with the status it carries
+func respondWithPolicyViolation(w http.ResponseWriter, err error) {
+	status := http.StatusUnprocessableEntity
+	var violation *policyViolation
+	if errors.As(err, &violation) {
+		status = violation.status
+	}
+	respondWithError(w, status, err.Error(), err)
+}
+
+func fileSizeLimitMessage(tier string, limit int64) string {
+	return fmt.Sprintf("The %s tier is limited to files of %s", tier, formatFileSize(limit))
+}
+
+// checkContainer rejects media types the tier doesn't allow
+func (p uploadPolicy) checkContainer(tier, mediaType string) error {
+	if len(p.AllowedContainers) > 0 && !slices.Contains(p.AllowedContainers, mediaType) {
+		return newPolicyViolation(http.StatusUnprocessableEntity, "The %s tier doesn't allow %s uploads", tier, mediaType)
+	}
+	return nil
+}
+
+// checkVideoCount rejects a new video once the tier's video limit is reached
+func (p uploadPolicy) checkVideoCount(tier string, count int) error {
+	if p.MaxVideos > 0 && count >= p.MaxVideos {
+		return newPolicyViolation(http.StatusUnprocessableEntity, "The %s tier is limited to %d videos", tier, p.MaxVideos)
+	}
+	return nil
+}
+
+// checkStorageQuota rejects an upload that would take the user over the
+// tier's storage quota
+func (p uploadPolicy) checkStorageQuota(tier string, used, incoming int64) error {
+	if p.StorageQuota > 0 && used+incoming > p.StorageQuota {
+		return newPolicyViolation(http.StatusRequestEntityTooLarge, "The %s tier has a storage quota of %s and %s is already in use", tier, formatFileSize(p.StorageQuota), formatFileSize(used))
+	}
+	return nil
+}
+
+// checkProbed rejects videos whose probed duration or resolution is over the
+// limit. Resolution limits apply to either orientation, so a 1920x1080 limit
+// also accepts 1080x1920.
+func (p uploadPolicy) checkProbed(tier string, duration float64, width, height int) error {
+	if p.MaxDuration > 0 && duration > p.MaxDuration {
+		return newPolicyViolation(http.StatusUnprocessableEntity, "The %s tier is limited to videos of %g seconds, this one is %.1f seconds", tier, p.MaxDuration, duration)
+	}
+	if p.MaxWidth > 0 && p.MaxHeight > 0 {
+		maxLong, maxShort := max(p.MaxWidth, p.MaxHeight), min(p.MaxWidth, p.MaxHeight)
+		if max(width, height) > maxLong || min(width, height) > maxShort {
+			return newPolicyViolation(http.StatusUnprocessableEntity, "The %s tier is limited to a resolution of %dx%d, this video is %dx%d", tier, p.MaxWidth, p.MaxHeight, width, height)
+		}
+	}
+	return nil
+}
+
+// formatFileSize renders a byte count for error messages
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.4g%cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
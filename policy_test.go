@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadUploadPoliciesFileSize(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           string
+		mediaMaxFileSize int64
+		want             map[string]int64
+		wantErr          string
+	}{
+		{
+			name:             "defaults",
+			mediaMaxFileSize: 4 << 30,
+			want:             map[string]int64{"free": 1 << 30},
+		},
+		{
+			name:             "defaults under a lower media limit",
+			mediaMaxFileSize: 512 << 20,
+			want:             map[string]int64{"free": 512 << 20},
+		},
+		{
+			name:             "tier without a limit gets the default",
+			config:           `{"tiers": {"free": {"max_file_size": 1000}, "pro": {}}}`,
+			mediaMaxFileSize: 4 << 30,
+			want:             map[string]int64{"free": 1000, "pro": 1 << 30},
+		},
+		{
+			name:             "limit at the media limit",
+			config:           `{"tiers": {"free": {"max_file_size": 4294967296}}}`,
+			mediaMaxFileSize: 4 << 30,
+			want:             map[string]int64{"free": 4 << 30},
+		},
+		{
+			name:   "no media limit",
+			config: `{"tiers": {"free": {"max_file_size": 10737418240}}}`,
+			want:   map[string]int64{"free": 10 << 30},
+		},
+		{
+			name:             "limit above the media limit",
+			config:           `{"tiers": {"free": {}, "pro": {"max_file_size": 10737418240}}}`,
+			mediaMaxFileSize: 4 << 30,
+			wantErr:          `max_file_size of tier "pro"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.config != "" {
+				path = filepath.Join(t.TempDir(), "policies.json")
+				if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			policies, err := loadUploadPolicies(path, tt.mediaMaxFileSize)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadUploadPolicies: %v", err)
+			}
+			for tier, want := range tt.want {
+				if got := policies.Tiers[tier].MaxFileSize; got != want {
+					t.Errorf("tier %s: got max_file_size %d, want %d", tier, got, want)
+				}
+			}
+		})
+	}
+	if defaultUploadPolicies.Tiers[defaultUserTier].MaxFileSize != 0 {
+		t.Error("loading the defaults changed defaultUploadPolicies")
+	}
+}
+
+func TestUploadPolicyChecks(t *testing.T) {
+	policy := uploadPolicy{
+		AllowedContainers: []string{"video/mp4"},
+		MaxVideos:         3,
+		StorageQuota:      1000,
+		MaxDuration:       60,
+		MaxWidth:          1920,
+		MaxHeight:         1080,
+	}
+	tests := []struct {
+		name       string
+		policy     uploadPolicy
+		check      func(p uploadPolicy) error
+		wantStatus int // 0 when the check passes
+	}{
+		{
+			name:   "allowed container",
+			policy: policy,
+			check:  func(p uploadPolicy) error { return p.checkContainer("free", "video/mp4") },
+		},
+		{
+			name:       "container not allowed",
+			policy:     policy,
+			check:      func(p uploadPolicy) error { return p.checkContainer("free", "video/quicktime") },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "any supported container",
+			policy: uploadPolicy{},
+			check:  func(p uploadPolicy) error { return p.checkContainer("free", "video/mp4") },
+		},
+		{
+			name:   "below the video limit",
+			policy: policy,
+			check:  func(p uploadPolicy) error { return p.checkVideoCount("free", 2) },
+		},
+		{
+			name:       "at the video limit",
+			policy:     policy,
+			check:      func(p uploadPolicy) error { return p.checkVideoCount("free", 3) },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "no video limit",
+			policy: uploadPolicy{},
+			check:  func(p uploadPolicy) error { return p.checkVideoCount("free", 1000) },
+		},
+		{
+			name:   "fills the storage quota",
+			policy: policy,
+			check:  func(p uploadPolicy) error { return p.checkStorageQuota("free", 600, 400) },
+		},
+		{
+			name:       "over the storage quota",
+			policy:     policy,
+			check:      func(p uploadPolicy) error { return p.checkStorageQuota("free", 600, 401) },
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "no storage quota",
+			policy: uploadPolicy{},
+			check:  func(p uploadPolicy) error { return p.checkStorageQuota("free", 1<<40, 1<<40) },
+		},
+		{
+			name:   "portrait within the resolution limit",
+			policy: policy,
+			check:  func(p uploadPolicy) error { return p.checkProbed("free", 60, 1080, 1920) },
+		},
+		{
+			name:       "over the resolution limit",
+			policy:     policy,
+			check:      func(p uploadPolicy) error { return p.checkProbed("free", 30, 3840, 2160) },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "over the duration limit",
+			policy:     policy,
+			check:      func(p uploadPolicy) error { return p.checkProbed("free", 60.5, 1280, 720) },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check(tt.policy)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			var violation *policyViolation
+			if !errors.As(err, &violation) {
+				t.Fatalf("got error %v, want a policy violation", err)
+			}
+			if violation.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d", violation.status, tt.wantStatus)
+			}
+		})
+	}
+}
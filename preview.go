@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Number of evenly spaced clips stitched together into a preview
+const previewSegments = 4
+
+type previewConfig struct {
+	seconds float64
+	fps     int
+	width   int
+}
+
+// generatePreviews renders the animated WebP and MP4 hover previews into the
+// assets directory and returns their asset paths
+func (cfg apiConfig) generatePreviews(ctx context.Context, inputFilePath string, duration float64, owner objectOwner) (webpPath, mp4Path string, err error) {
+	webpPath = getAssetPath("image/webp")
+	err = cfg.processVideoForPreview(ctx, inputFilePath, cfg.getAssetDiskPath(webpPath), duration, cfg.preview,
+		"-c:v", "libwebp", "-loop", "0", "-q:v", "60", "-f", "webp")
+	if err != nil {
+		return "", "", err
+	}
+
+	mp4Path = getAssetPath("video/mp4")
+	err = cfg.processVideoForPreview(ctx, inputFilePath, cfg.getAssetDiskPath(mp4Path), duration, cfg.preview,
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "faststart", "-f", "mp4")
+	if err != nil {
+		os.Remove(cfg.getAssetDiskPath(webpPath))
+		return "", "", err
+	}
+
+	cfg.recordStoredFile(ctx, assetsLocation, webpPath, cfg.getAssetDiskPath(webpPath), owner)
+	cfg.recordStoredFile(ctx, assetsLocation, mp4Path, cfg.getAssetDiskPath(mp4Path), owner)
+	return webpPath, mp4Path, nil
+}
+
+func (cfg apiConfig) processVideoForPreview(ctx context.Context, inputFilePath, outputFilePath string, duration float64, preview previewConfig, codecArgs ...string) error {
+	// Take short clips from across the whole video instead of just its start
+	interval := duration / previewSegments
+	clipLength := preview.seconds / previewSegments
+	if interval <= clipLength {
+		interval, clipLength = duration, duration
+	}
+	filter := fmt.Sprintf(
+		"fps=%d,select='lt(mod(t\\,%f)\\,%f)',setpts=N/(%d*TB),scale=%d:-2",
+		preview.fps, interval, clipLength, preview.fps, preview.width,
+	)
+
+	args := []string{"-i", inputFilePath, "-vf", filter, "-an", "-t", fmt.Sprintf("%f", preview.seconds)}
+	args = append(args, codecArgs...)
+	args = append(args, outputFilePath)
+	if _, err := cfg.media.Run(ctx, "ffmpeg", args...); err != nil {
+		os.Remove(outputFilePath)
+		return fmt.Errorf("error generating preview: %w", err)
+	}
+
+	fileInfo, err := os.Stat(outputFilePath)
+	if err != nil {
+		return fmt.Errorf("could not stat preview file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		os.Remove(outputFilePath)
+		return fmt.Errorf("preview file is empty")
+	}
+	return nil
+}
+
+func (cfg apiConfig) getVideoDuration(ctx context.Context, filePath string) (float64, error) {
+	out, err := cfg.media.Run(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_entries", "format=duration", filePath)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe error: %w", err)
+	}
+	var output struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	err = json.Unmarshal(out, &output)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	duration, err := strconv.ParseFloat(output.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse video duration: %v", err)
+	}
+	return duration, nil
+}
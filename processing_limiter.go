@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+var errProcessingQueueFull = errors.New("processing queue is full")
+
+// processingLimiter bounds how many uploads are processed at once and how
+// many more may wait for a free slot
+type processingLimiter struct {
+	slots chan struct{}
+	queue chan struct{}
+}
+
+func newProcessingLimiter(concurrency, queueSize int) *processingLimiter {
+	return &processingLimiter{
+		slots: make(chan struct{}, concurrency),
+		queue: make(chan struct{}, queueSize),
+	}
+}
+
+// acquire waits for a processing slot and returns the function that frees it.
+// It fails right away with errProcessingQueueFull when the wait queue is full.
+func (l *processingLimiter) acquire(ctx context.Context) (func(), error) {
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return nil, errProcessingQueueFull
+	}
+	defer func() { <-l.queue }()
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForQueue blocks until n callers are waiting for a slot
+func waitForQueue(t *testing.T, l *processingLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(l.queue) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d callers waiting, want %d", len(l.queue), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProcessingLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		queueSize   int
+		held        int // slots taken before the acquire
+		waiting     int // callers already queued for a slot
+		wantErr     error
+	}{
+		{
+			name:        "free slot",
+			concurrency: 2,
+			queueSize:   1,
+			held:        1,
+		},
+		{
+			name:        "waits until cancelled",
+			concurrency: 1,
+			queueSize:   2,
+			held:        1,
+			waiting:     1,
+			wantErr:     context.DeadlineExceeded,
+		},
+		{
+			name:        "queue full",
+			concurrency: 1,
+			queueSize:   1,
+			held:        1,
+			waiting:     1,
+			wantErr:     errProcessingQueueFull,
+		},
+		{
+			name:        "no queue",
+			concurrency: 1,
+			queueSize:   0,
+			held:        1,
+			wantErr:     errProcessingQueueFull,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newProcessingLimiter(tt.concurrency, tt.queueSize)
+			for range tt.held {
+				if _, err := l.acquire(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitCtx, cancelWaiting := context.WithCancel(context.Background())
+			defer cancelWaiting()
+			for range tt.waiting {
+				go l.acquire(waitCtx)
+			}
+			waitForQueue(t, l, tt.waiting)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			release, err := l.acquire(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				release()
+			}
+			// A caller that gave up leaves the queue
+			waitForQueue(t, l, tt.waiting)
+		})
+	}
+}
+
+func TestProcessingLimiterRelease(t *testing.T) {
+	l := newProcessingLimiter(1, 1)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		release, err := l.acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	waitForQueue(t, l, 1)
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquired a slot while none was free: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("got error %v once the slot was released", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter didn't get the released slot")
+	}
+	waitForQueue(t, l, 0)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	errNoOriginal = errors.New("video has no stored original")
+	// errReprocessSkipped is returned for videos that changed so much since
+	// they were selected that reprocessing them no longer makes sense
+	errReprocessSkipped = errors.New("skipped")
+)
+
+// equalPointers reports whether both are nil or point to equal values
+func equalPointers[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// reprocessVideo runs a video's stored original through the current pipeline
+func (cfg *apiConfig) reprocessVideo(ctx context.Context, video database.Video) error {
+	if video.OriginalKey == nil {
+		return errNoOriginal
+	}
+
+	release, err := cfg.processing.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return cfg.reprocessWithSlot(ctx, video)
+}
+
+// reprocessWithSlot is reprocessVideo for callers that already hold a
+// processing slot
+func (cfg *apiConfig) reprocessWithSlot(ctx context.Context, video database.Video) error {
+	if video.OriginalKey == nil {
+		return errNoOriginal
+	}
+
+	// The video may have been edited, re-uploaded or trashed since it was read
+	latest, err := cfg.db.GetVideoContext(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case latest.DeletedAt != nil:
+		return fmt.Errorf("%w: video is in the trash", errReprocessSkipped)
+	case !equalPointers(latest.OriginalKey, video.OriginalKey) || !equalPointers(latest.CurrentVersionID, video.CurrentVersionID):
+		return fmt.Errorf("%w: video file changed", errReprocessSkipped)
+	}
+	video = latest
+
+	inputPath, err := cfg.downloadFromBucket(ctx, cfg.s3OriginalsBucket, *video.OriginalKey, "tubely-original-*.mp4")
+	if err != nil {
+		return fmt.Errorf("couldn't download original: %w", err)
+	}
+	defer os.Remove(inputPath)
+
+	job := &videoJob{
+		video:        video,
+		inputPath:    inputPath,
+		mediaType:    "video/mp4",
+		reprocessing: true,
+	}
+	if video.VerticalMode != nil {
+		job.verticalMode = *video.VerticalMode
+	}
+	defer job.removeTempFiles()
+
+	_, err = cfg.pipeline.run(ctx, cfg, job)
+	return err
+}
+
+// reprocessInBackground reprocesses a video without holding up the request
+// that changed it, using the processing slot the request took and freeing it
+// when done. Failures are only logged.
+func (cfg *apiConfig) reprocessInBackground(video database.Video, release func()) {
+	go func() {
+		defer release()
+		if err := cfg.reprocessWithSlot(context.Background(), video); err != nil {
+			log.Printf("Couldn't reprocess video %s: %v", video.ID, err)
+		}
+	}()
+}
+
+type reprocessFailure struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Error   string    `json:"error"`
+}
+
+type reprocessResult struct {
+	Reprocessed []uuid.UUID        `json:"reprocessed"`
+	Skipped     []reprocessFailure `json:"skipped"`
+	Failed      []reprocessFailure `json:"failed"`
+}
+
+// reprocessVideos reprocesses the videos one at a time, carrying on past failures
+func (cfg *apiConfig) reprocessVideos(ctx context.Context, videos []database.Video) reprocessResult {
+	result := reprocessResult{
+		Reprocessed: []uuid.UUID{},
+		Skipped:     []reprocessFailure{},
+		Failed:      []reprocessFailure{},
+	}
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			result.Failed = append(result.Failed, reprocessFailure{VideoID: video.ID, Error: ctx.Err().Error()})
+			continue
+		}
+		err := cfg.reprocessVideo(ctx, video)
+		if errors.Is(err, errReprocessSkipped) {
+			result.Skipped = append(result.Skipped, reprocessFailure{VideoID: video.ID, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't reprocess video %s: %v", video.ID, err)
+			result.Failed = append(result.Failed, reprocessFailure{VideoID: video.ID, Error: err.Error()})
+			continue
+		}
+		result.Reprocessed = append(result.Reprocessed, video.ID)
+	}
+	return result
+}
+
+// reprocessSelection picks the videos to reprocess: a single video, all of a
+// user's videos, or every video processed by an outdated pipeline
+type reprocessSelection struct {
+	VideoID  *uuid.UUID `json:"video_id"`
+	UserID   *uuid.UUID `json:"user_id"`
+	Outdated bool       `json:"outdated"`
+}
+
+func (cfg *apiConfig) selectVideosForReprocessing(ctx context.Context, selection reprocessSelection) ([]database.Video, error) {
+	selected := 0
+	if selection.VideoID != nil {
+		selected++
+	}
+	if selection.UserID != nil {
+		selected++
+	}
+	if selection.Outdated {
+		selected++
+	}
+	if selected != 1 {
+		return nil, errors.New("choose exactly one of a video, a user or outdated videos")
+	}
+
+	switch {
+	case selection.VideoID != nil:
+		video, err := cfg.db.GetVideoContext(ctx, *selection.VideoID)
+		if err != nil {
+			return nil, err
+		}
+		return []database.Video{video}, nil
+	case selection.UserID != nil:
+		videos, err := cfg.db.GetVideosContext(ctx, *selection.UserID)
+		if err != nil {
+			return nil, err
+		}
+		// Videos in the trash aren't worth the work
+		return slices.DeleteFunc(videos, func(video database.Video) bool {
+			return video.DeletedAt != nil
+		}), nil
+	default:
+		return cfg.db.GetVideosForReprocessingContext(ctx, cfg.pipeline.version)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// How long a finished reprocess job can still be looked up
+const reprocessJobRetention = 24 * time.Hour
+
+const (
+	reprocessJobRunning = "running"
+	reprocessJobDone    = "done"
+)
+
+// reprocessJob is a batch of videos being reprocessed in the background
+type reprocessJob struct {
+	ID         uuid.UUID  `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	// Filled in once the job is done
+	Result *reprocessResult `json:"result"`
+}
+
+// reprocessJobs keeps the reprocess jobs started by this server in memory, so
+// they're gone after a restart
+type reprocessJobs struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*reprocessJob
+}
+
+func newReprocessJobs() *reprocessJobs {
+	return &reprocessJobs{jobs: map[uuid.UUID]*reprocessJob{}}
+}
+
+// get returns a copy of the job, or false if there's no such job
+func (j *reprocessJobs) get(id uuid.UUID) (reprocessJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return reprocessJob{}, false
+	}
+	return *job, true
+}
+
+// start registers a job for the videos and reprocesses them in the
+// background, returning the job as it was when it started
+func (j *reprocessJobs) start(cfg *apiConfig, videos []database.Video) reprocessJob {
+	job := &reprocessJob{
+		ID:        uuid.New(),
+		Status:    reprocessJobRunning,
+		Total:     len(videos),
+		StartedAt: time.Now().UTC(),
+	}
+
+	j.mu.Lock()
+	j.pruneLocked(job.StartedAt)
+	j.jobs[job.ID] = job
+	started := *job
+	j.mu.Unlock()
+
+	go func() {
+		result := cfg.reprocessVideos(context.Background(), videos)
+		finishedAt := time.Now().UTC()
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		job.Status = reprocessJobDone
+		job.FinishedAt = &finishedAt
+		job.Result = &result
+	}()
+
+	return started
+}
+
+// pruneLocked forgets jobs that finished more than reprocessJobRetention ago
+func (j *reprocessJobs) pruneLocked(now time.Time) {
+	for id, job := range j.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > reprocessJobRetention {
+			delete(j.jobs, id)
+		}
+	}
+}
@@ -9,11 +9,13 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := cfg.db.Reset()
+	err := cfg.db.ResetContext(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't reset database", err)
+		respondWithDatabaseError(w, "Couldn't reset database", err)
 		return
 	}
+	// The audit log is append-only, so it survives the reset and records it
+	cfg.recordAudit(r, auditEntry{action: auditAdminReset})
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Database reset to initial state"))
 }
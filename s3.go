@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// putFileInS3 uploads a file from disk to the bucket under the given key and
+// counts it towards the owner's storage usage
+func (cfg *apiConfig) putFileInS3(ctx context.Context, filePath, key, contentType string, owner objectOwner) error {
+	return cfg.putFileInBucket(ctx, cfg.s3Bucket, filePath, key, contentType, owner)
+}
+
+func (cfg *apiConfig) putFileInBucket(ctx context.Context, bucket, filePath, key, contentType string, owner objectOwner) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("could not open file: %v", err)
+	}
+	defer file.Close()
+
+	_, err = cfg.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return err
+	}
+	cfg.recordStoredFile(ctx, bucketLocation(bucket), key, filePath, owner)
+	return nil
+}
+
+// downloadFromBucket copies an object into a new temp file in the assets
+// directory and returns the file's path
+func (cfg *apiConfig) downloadFromBucket(ctx context.Context, bucket, key, pattern string) (string, error) {
+	output, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer output.Body.Close()
+
+	file, err := os.CreateTemp(cfg.assetsRoot, pattern)
+	if err != nil {
+		return "", fmt.Errorf("could not create temp file: %v", err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, output.Body); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("could not write file to disk: %v", err)
+	}
+	return file.Name(), nil
+}
+
+func (cfg *apiConfig) deleteFromBucket(ctx context.Context, bucket, key string) error {
+	_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.db.DeleteStoredObjectContext(ctx, bucketLocation(bucket), key)
+}
+
+func (cfg *apiConfig) getCloudFrontURL(key string) string {
+	return fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
+}
+
+// keyFromCloudFrontURL is the reverse of getCloudFrontURL
+func (cfg *apiConfig) keyFromCloudFrontURL(url *string) (string, bool) {
+	if url == nil {
+		return "", false
+	}
+	return strings.CutPrefix(*url, cfg.s3CfDistribution+"/")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Categories stored objects are reported under
+const (
+	storageVideos      = "videos"
+	storageOriginals   = "originals"
+	storageThumbnails  = "thumbnails"
+	storageDerivatives = "derivatives"
+)
+
+// Location of the files kept in the assets directory
+const assetsLocation = "assets"
+
+func bucketLocation(bucket string) string {
+	return "s3://" + bucket
+}
+
+// objectOwner says whose usage a stored object counts towards
+type objectOwner struct {
+	userID   uuid.UUID
+	videoID  uuid.UUID
+	category string
+	// Collects every object stored for the owner, when set
+	stored *[]database.StoredObject
+}
+
+func videoOwner(video database.Video, category string) objectOwner {
+	return objectOwner{userID: video.UserID, videoID: video.ID, category: category}
+}
+
+// recordStoredFile counts a file that was just stored against its owner. The
+// file is already stored by then, so failures are only logged.
+func (cfg apiConfig) recordStoredFile(ctx context.Context, location, key, filePath string, owner objectOwner) {
+	if owner.stored != nil {
+		*owner.stored = append(*owner.stored, database.StoredObject{Location: location, Key: key})
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		log.Printf("Couldn't record storage of %s/%s: %v", location, key, err)
+		return
+	}
+	err = cfg.db.RecordStoredObjectContext(ctx, database.StoredObject{
+		Location: location,
+		Key:      key,
+		UserID:   owner.userID,
+		VideoID:  owner.videoID,
+		Category: owner.category,
+		Size:     info.Size(),
+	})
+	if err != nil {
+		log.Printf("Couldn't record storage of %s/%s: %v", location, key, err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	maxVideoTags      = 20
+	maxTagLength      = 50
+	maxCategoryLength = 50
+)
+
+// validateTags checks tags the way they'll be stored: normalized, so limits
+// apply after case and whitespace are folded
+func validateTags(tags []string) error {
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		normalized := database.NormalizeTag(tag)
+		if normalized == "" {
+			return fmt.Errorf("tags can't be empty")
+		}
+		if utf8.RuneCountInString(normalized) > maxTagLength {
+			return fmt.Errorf("tag %q is longer than %d characters", normalized, maxTagLength)
+		}
+		seen[normalized] = true
+	}
+	if len(seen) > maxVideoTags {
+		return fmt.Errorf("a video can have at most %d tags", maxVideoTags)
+	}
+	return nil
+}
+
+// validateCategory accepts no category, or one within the length limit
+func validateCategory(category *string) error {
+	if category == nil {
+		return nil
+	}
+	if utf8.RuneCountInString(database.NormalizeTag(*category)) > maxCategoryLength {
+		return fmt.Errorf("category is longer than %d characters", maxCategoryLength)
+	}
+	return nil
+}
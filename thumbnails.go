@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Register the decoders for the accepted thumbnail types
+	_ "image/jpeg"
+)
+
+const (
+	maxThumbnailWidth  = 8000
+	maxThumbnailHeight = 8000
+)
+
+// Widths of the responsive thumbnail variants
+var thumbnailWidths = []int{320, 640, 1280}
+
+var thumbnailFormats = []struct {
+	mediaType string
+	codecArgs []string
+}{
+	{mediaType: "image/jpeg", codecArgs: []string{"-q:v", "3", "-f", "image2"}},
+	{mediaType: "image/webp", codecArgs: []string{"-c:v", "libwebp", "-q:v", "80", "-f", "webp"}},
+}
+
+// writeCleanImage re-encodes a decoded image as PNG, which leaves behind any
+// EXIF/GPS metadata the original file carried
+func writeCleanImage(img image.Image, dir string) (string, error) {
+	f, err := os.CreateTemp(dir, "tubely-thumbnail-*.png")
+	if err != nil {
+		return "", fmt.Errorf("could not create temp file: %v", err)
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, img); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("could not encode image: %v", err)
+	}
+	return f.Name(), nil
+}
+
+// generateThumbnailVariants resizes the image into every width and format,
+// stores the results in the assets directory and returns a srcset per media type
+func (cfg apiConfig) generateThumbnailVariants(ctx context.Context, inputFilePath string, originalWidth int, owner objectOwner) (map[string]string, error) {
+	widths := []int{}
+	for _, width := range thumbnailWidths {
+		if width < originalWidth {
+			widths = append(widths, width)
+		}
+	}
+	// Small images still get one variant at their own size
+	if len(widths) == 0 || originalWidth <= thumbnailWidths[len(thumbnailWidths)-1] {
+		widths = append(widths, originalWidth)
+	}
+
+	srcset := map[string]string{}
+	created := []string{}
+	for _, format := range thumbnailFormats {
+		candidates := []string{}
+		for _, width := range widths {
+			assetPath := getAssetPath(format.mediaType)
+			assetDiskPath := cfg.getAssetDiskPath(assetPath)
+			if err := cfg.resizeImage(ctx, inputFilePath, assetDiskPath, width, format.codecArgs); err != nil {
+				for _, path := range created {
+					os.Remove(path)
+				}
+				return nil, err
+			}
+			created = append(created, assetDiskPath)
+			candidates = append(candidates, fmt.Sprintf("%s %dw", cfg.getAssetURL(assetPath), width))
+		}
+		srcset[format.mediaType] = strings.Join(candidates, ", ")
+	}
+	for _, assetDiskPath := range created {
+		cfg.recordStoredFile(ctx, assetsLocation, filepath.Base(assetDiskPath), assetDiskPath, owner)
+	}
+	return srcset, nil
+}
+
+func (cfg apiConfig) resizeImage(ctx context.Context, inputFilePath, outputFilePath string, width int, codecArgs []string) error {
+	args := []string{"-i", inputFilePath, "-vf", fmt.Sprintf("scale=%d:-2", width), "-map_metadata", "-1", "-frames:v", "1"}
+	args = append(args, codecArgs...)
+	args = append(args, outputFilePath)
+	if _, err := cfg.media.Run(ctx, "ffmpeg", args...); err != nil {
+		os.Remove(outputFilePath)
+		return fmt.Errorf("error resizing thumbnail: %w", err)
+	}
+	return nil
+}
+
+// largestSrcsetURL returns the URL of the last, widest candidate in a srcset
+func largestSrcsetURL(srcset string) string {
+	candidates := strings.Split(srcset, ", ")
+	return strings.Fields(candidates[len(candidates)-1])[0]
+}
+
+// srcsetURLs returns the URL of every candidate in a srcset
+func srcsetURLs(srcset string) []string {
+	urls := []string{}
+	for _, candidate := range strings.Split(srcset, ", ") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// purgeVideo permanently deletes a video along with everything stored for it
+func (cfg *apiConfig) purgeVideo(ctx context.Context, video database.Video) error {
+	if err := cfg.deleteVideoFiles(ctx, video); err != nil {
+		return err
+	}
+	return cfg.db.DeleteVideoContext(ctx, video.ID)
+}
+
+// purgeExpiredTrash purges the videos that have been in the trash for longer
+// than the retention period. A video that fails is left for the next run.
+func (cfg *apiConfig) purgeExpiredTrash(ctx context.Context) (int, error) {
+	videos, err := cfg.db.GetVideosTrashedBeforeContext(ctx, time.Now().Add(-cfg.trashRetention))
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, video := range videos {
+		if err := cfg.purgeVideo(ctx, video); err != nil {
+			log.Printf("Couldn't purge video %s from the trash: %v", video.ID, err)
+			continue
+		}
+		cfg.recordAudit(nil, auditEntry{
+			action:     auditVideoPurge,
+			targetType: "video",
+			targetID:   video.ID.String(),
+			before:     video,
+		})
+		purged++
+	}
+	return purged, nil
+}
+
+// runTrashPurge empties expired videos out of the trash every interval until
+// ctx is done
+func (cfg *apiConfig) runTrashPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := cfg.purgeExpiredTrash(ctx)
+		if err != nil {
+			log.Printf("Couldn't purge the trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d videos from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultVideoPageSize = 50
+	maxVideoPageSize     = 200
+)
+
+// Cursors are opaque to clients: the ID of the last video or event on the page
+func encodeCursor(id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+func decodeCursor(cursor string) (uuid.UUID, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return uuid.UUID{}, errors.New("invalid cursor")
+	}
+	id, err := uuid.FromBytes(data)
+	if err != nil {
+		return uuid.UUID{}, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// parseListVideosParams reads the paging, sorting and filtering options of
+// the videos listing from its query string
+func parseListVideosParams(query url.Values) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{
+		SortBy: "created_at",
+		Limit:  defaultVideoPageSize,
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		switch sort {
+		case "created_at", "updated_at", "title", "duration":
+			params.SortBy = sort
+		default:
+			return params, errors.New("sort must be created_at, updated_at, title or duration")
+		}
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		params.Ascending = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxVideoPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxVideoPageSize)
+		}
+		params.Limit = n
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		id, err := decodeCursor(cursor)
+		if err != nil {
+			return params, err
+		}
+		params.After = &id
+	}
+
+	var err error
+	if params.HasVideo, err = parseOptionalBool(query, "has_video"); err != nil {
+		return params, err
+	}
+	if params.HasThumbnail, err = parseOptionalBool(query, "has_thumbnail"); err != nil {
+		return params, err
+	}
+	switch orientation := query.Get("orientation"); orientation {
+	case "", "landscape", "portrait", "other":
+		params.Orientation = orientation
+	default:
+		return params, errors.New("orientation must be landscape, portrait or other")
+	}
+	if params.CreatedAfter, err = parseOptionalTime(query, "created_after"); err != nil {
+		return params, err
+	}
+	if params.CreatedBefore, err = parseOptionalTime(query, "created_before"); err != nil {
+		return params, err
+	}
+	// tag may repeat, matching videos that have all of them
+	params.Tags = query["tag"]
+	params.Category = query.Get("category")
+	return params, nil
+}
+
+func parseOptionalBool(query url.Values, name string) (*bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &b, nil
+}
+
+func parseOptionalTime(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+	return &t, nil
+}
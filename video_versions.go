@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// versionObjects lists where a version's files are stored: the video and its
+// renditions in S3, its original and its local previews
+func (cfg *apiConfig) versionObjects(version database.VideoVersion) []database.StoredObject {
+	objects := []database.StoredObject{}
+	s3Location := bucketLocation(cfg.s3Bucket)
+	if version.ObjectKey != "" {
+		objects = append(objects, database.StoredObject{Location: s3Location, Key: version.ObjectKey})
+	}
+	files := version.Files
+	for _, url := range []*string{files.VerticalVideoURL, files.AudioURL, files.AudioMP3URL} {
+		if key, ok := cfg.keyFromCloudFrontURL(url); ok {
+			objects = append(objects, database.StoredObject{Location: s3Location, Key: key})
+		}
+	}
+	if files.OriginalKey != nil {
+		objects = append(objects, database.StoredObject{Location: bucketLocation(cfg.s3OriginalsBucket), Key: *files.OriginalKey})
+	}
+	for _, url := range []*string{files.PreviewURL, files.PreviewMP4URL} {
+		if assetPath, ok := cfg.assetPathFromURL(url); ok {
+			objects = append(objects, database.StoredObject{Location: assetsLocation, Key: assetPath})
+		}
+	}
+	return objects
+}
+
+// deleteVersionFiles removes everything stored for a version, except the
+// files that one of the inUse versions still refers to
+func (cfg *apiConfig) deleteVersionFiles(ctx context.Context, version database.VideoVersion, inUse []database.VideoVersion) error {
+	type location struct{ location, key string }
+	used := map[location]bool{}
+	for _, other := range inUse {
+		for _, object := range cfg.versionObjects(other) {
+			used[location{object.Location, object.Key}] = true
+		}
+	}
+	for _, object := range cfg.versionObjects(version) {
+		if used[location{object.Location, object.Key}] {
+			continue
+		}
+		if err := cfg.deleteStoredObject(ctx, object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionsInUse returns the versions of a video other than except, along with
+// the files of its live row as one more version. Files they refer to must be
+// kept when another version goes.
+func (cfg *apiConfig) versionsInUse(ctx context.Context, videoID, except uuid.UUID) ([]database.VideoVersion, error) {
+	versions, err := cfg.db.GetVideoVersionsContext(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	versions = slices.DeleteFunc(versions, func(version database.VideoVersion) bool {
+		return version.ID == except
+	})
+	video, err := cfg.db.GetVideoContext(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	live := database.VideoVersion{}
+	if key, ok := cfg.keyFromCloudFrontURL(video.VideoURL); ok {
+		live.ObjectKey = key
+	}
+	live.Files = video.Files()
+	return append(versions, live), nil
+}
+
+// purgeVideoVersion deletes a version that isn't the current one, files included
+func (cfg *apiConfig) purgeVideoVersion(ctx context.Context, version database.VideoVersion) error {
+	inUse, err := cfg.versionsInUse(ctx, version.VideoID, version.ID)
+	if err != nil {
+		return err
+	}
+	if err := cfg.deleteVersionFiles(ctx, version, inUse); err != nil {
+		return err
+	}
+	return cfg.db.DeleteVideoVersionContext(ctx, version.ID)
+}
+
+// pruneVideoVersions purges all but the newest keep versions of a video. The
+// current version is always kept and doesn't count towards keep.
+func (cfg *apiConfig) pruneVideoVersions(ctx context.Context, video database.Video, keep int) ([]database.VideoVersion, error) {
+	versions, err := cfg.db.GetVideoVersionsContext(ctx, video.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []database.VideoVersion{}
+	kept := 0
+	for _, version := range versions {
+		if video.CurrentVersionID != nil && version.ID == *video.CurrentVersionID {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if err := cfg.purgeVideoVersion(ctx, version); err != nil {
+			return purged, err
+		}
+		purged = append(purged, version)
+	}
+	return purged, nil
+}